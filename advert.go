@@ -0,0 +1,65 @@
+package iorate
+
+/*
+	Rate advertisements are 9-byte frames: the letter 'R' followed by
+	the rate as a big-endian 64-bit integer. The receiver of a transfer
+	writes them on its side of the connection whenever it wants a new
+	rate, and the sender reads them and applies them to its limiter.
+	This leaves the receiver-to-sender direction to the advertisements,
+	so it's meant for one-way transfers.
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const advertTag = 'R'
+
+// ErrBadAdvert is returned by FollowRate when the other side sends
+// something that isn't a rate advertisement.
+var ErrBadAdvert = errors.New("iorate: bad rate advertisement")
+
+// Asks the sender at the other end of 'w' to send at 'maxSpeed' bytes
+// per second. Zero asks for no limit.
+func AdvertiseRate(w io.Writer, maxSpeed Rate) error {
+	var frame [9]byte
+	frame[0] = advertTag
+	binary.BigEndian.PutUint64(frame[1:], uint64(maxSpeed))
+	_, err := w.Write(frame[:])
+	return err
+}
+
+// Reads rate advertisements from 'r' and applies them to 'l' until
+// reading fails. Returns io.EOF if the other side stops cleanly.
+// Advertised rates are capped at 'max', and asking for no limit gets
+// 'max' too. If 'max' is zero or less the other side may lift the
+// limit altogether. Rates that don't fit a Rate are ErrBadAdvert.
+// Typically runs in its own goroutine:
+//
+//	l := iorate.NewLimiter(initialRate)
+//	go iorate.FollowRate(conn, l, maxRate)
+//	io.Copy(l.Writer(conn), data)
+func FollowRate(r io.Reader, l *Limiter, max Rate) error {
+	var frame [9]byte
+	for {
+		if _, err := io.ReadFull(r, frame[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return ErrBadAdvert
+			}
+			return err
+		}
+		if frame[0] != advertTag {
+			return ErrBadAdvert
+		}
+		rate := Rate(binary.BigEndian.Uint64(frame[1:]))
+		if rate < 0 {
+			return ErrBadAdvert
+		}
+		if max > 0 && (rate == 0 || rate > max) {
+			rate = max
+		}
+		l.SetRate(rate)
+	}
+}
@@ -0,0 +1,67 @@
+package iorate
+
+import (
+	"sync"
+	"time"
+)
+
+// A temporary rate of a limiter.
+type boost struct {
+	rate Rate
+}
+
+// Raises the limiter's rate to 'maxSpeed' bytes per second for the
+// time 'd', after which the rate goes back by itself, and returns a
+// function that ends the boost early. A rate of zero or less lifts
+// the limit for the time. Boosts can overlap: the limiter runs at the
+// highest of its own rate and the boosts in effect, so ending one
+// boost leaves the others as they are. A rate set with SetRate during
+// a boost takes over when all boosts have ended, or earlier if it's
+// higher.
+func (l *Limiter) Boost(maxSpeed Rate, d time.Duration) (cancel func()) {
+	b := &boost{maxSpeed}
+	l.mu.Lock()
+	l.boosts = append(l.boosts, b)
+	l.applyRate()
+	l.mu.Unlock()
+
+	var once sync.Once
+	end := func() {
+		once.Do(func() {
+			l.mu.Lock()
+			for i, x := range l.boosts {
+				if x == b {
+					l.boosts = append(l.boosts[:i], l.boosts[i+1:]...)
+					break
+				}
+			}
+			l.applyRate()
+			l.mu.Unlock()
+		})
+	}
+	timer := time.AfterFunc(d, end)
+	return func() {
+		timer.Stop()
+		end()
+	}
+}
+
+// Sets the rate to the highest of the base rate and the boosts. Must
+// be called with the lock held.
+func (l *Limiter) applyRate() {
+	rate := l.base
+	for _, b := range l.boosts {
+		if rate <= 0 || b.rate <= 0 {
+			rate = 0
+			break
+		}
+		if b.rate > rate {
+			rate = b.rate
+		}
+	}
+	if rate != l.rate && l.cert != nil {
+		l.cert.reset()
+	}
+	l.rate = rate
+	l.perSlice = sliceSize(rate, l.slice)
+}
@@ -0,0 +1,282 @@
+package iorate
+
+/*
+	A buffered reader puts a bounded buffer between the source and the
+	limited reader. A goroutine reads from the source as long as there
+	is room in the buffer, so a fast producer like another process
+	writing to a pipe isn't stalled by every pause of the limiter, and
+	the limited reader takes its data from the buffer. When the buffer
+	is full the goroutine stops reading, and the source's own flow
+	control, like a pipe blocking its writer, pushes back on the
+	producer. Nothing is dropped.
+
+	Closing the reader stops the goroutine. If it's blocked reading
+	from the source, closing the source is what gets it out, so Close
+	closes the source too when it can. The buffer goes back to a pool
+	once the goroutine is done with it.
+*/
+
+import (
+	"io"
+	"sync"
+)
+
+// Buffer size used when none is given.
+const defaultBufferSize = 32 * 1024
+
+// Buffers of the default size kept for reuse.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, defaultBufferSize)
+	},
+}
+
+// Number of empty reads from the source after which the buffer gives
+// up with io.ErrNoProgress, as bufio does.
+const maxEmptyReads = 100
+
+// BufferStats describes the occupancy of a buffered reader's buffer.
+type BufferStats struct {
+	// Capacity of the buffer
+	Size int
+	// Bytes currently in the buffer and the most it has ever held
+	Buffered, Peak int
+	// Number of times the buffer has filled up and reading from
+	// the source paused
+	Full int64
+}
+
+// A limited reader reading ahead from its source into a buffer.
+type bufferedReader struct {
+	*reader
+	ahead *readAhead
+}
+
+// A bounded buffer filled from a reader by a goroutine.
+type readAhead struct {
+	in   io.Reader
+	mu   sync.Mutex
+	cond *sync.Cond
+	// The buffer and the position and length of the data in it
+	data    []byte
+	head, n int
+	// The error the source has returned, if any, and whether it
+	// has been returned to the reader
+	err      error
+	reported bool
+	// Whether the goroutine is running and whether the reader
+	// has been closed
+	running, closed bool
+
+	peak  int
+	full  int64
+	marks *watermarks
+}
+
+// Returns a reader limited to 'maxSpeed' bytes per second that reads
+// ahead from 'in' into a buffer of 'size' bytes, or 32 KB if 'size' is
+// zero or less. Reading ahead starts at once.
+func NewBufferedReader(in io.Reader, maxSpeed Rate, size int) *bufferedReader {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	b := new(readAhead)
+	b.in = in
+	b.cond = sync.NewCond(&b.mu)
+	if size == defaultBufferSize {
+		b.data = bufferPool.Get().([]byte)
+	} else {
+		b.data = make([]byte, size)
+	}
+	b.running = true
+	go b.fill()
+	return &bufferedReader{NewReader(b, maxSpeed), b}
+}
+
+// Returns the occupancy of the buffer.
+func (t *bufferedReader) Buffered() BufferStats {
+	b := t.ahead
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BufferStats{len(b.data), b.n, b.peak, b.full}
+}
+
+// Sets flow control callbacks on the buffer's occupancy. 'onHigh' is
+// called when the buffer holds 'high' bytes or more, and after that
+// 'onLow' is called once it holds 'low' bytes or less. This is for
+// sources that can be asked to pause, where blocking the source
+// isn't enough. The callbacks must not read from the reader.
+func (t *bufferedReader) SetWatermarks(low, high int, onHigh, onLow func()) {
+	m := new(watermarks)
+	m.low = low
+	m.high = high
+	m.onHigh = onHigh
+	m.onLow = onLow
+	b := t.ahead
+	b.mu.Lock()
+	m.pending = b.n
+	b.marks = m
+	b.mu.Unlock()
+}
+
+// Returns the underlying reader.
+func (t *bufferedReader) Unwrap() io.Reader {
+	return t.ahead.in
+}
+
+// Drops the data that has been read ahead but not read and returns
+// its size. Reading ahead goes on.
+func (t *bufferedReader) Discard() int {
+	b := t.ahead
+	b.mu.Lock()
+	n := b.n
+	// Move the head rather than resetting it, the goroutine may be
+	// reading into the space after the data.
+	b.head = (b.head + b.n) % len(b.data)
+	b.n = 0
+	marks := b.marks
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	marks.add(-n)
+	return n
+}
+
+// Stops reading ahead, drops the buffered data and closes the source
+// if it's an io.Closer. Returns the error the source failed with if
+// it hasn't been returned by Read yet, otherwise the error of closing
+// the source. Reads after Close fail with ErrClosed.
+func (t *bufferedReader) Close() error {
+	b := t.ahead
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	var pending error
+	if !b.reported && b.err != io.EOF {
+		pending = b.err
+	}
+	n := b.n
+	b.n = 0
+	if !b.running {
+		b.release()
+	}
+	marks := b.marks
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	marks.add(-n)
+
+	var err error
+	if c, ok := b.in.(io.Closer); ok {
+		err = c.Close()
+	}
+	if pending != nil {
+		return pending
+	}
+	return err
+}
+
+// Gives the buffer back to the pool. Must be called with the lock held
+// once the goroutine has stopped.
+func (b *readAhead) release() {
+	if len(b.data) == defaultBufferSize {
+		bufferPool.Put(b.data)
+	}
+	b.data = nil
+}
+
+// Reads from the source until it fails or ends or the reader is
+// closed.
+func (b *readAhead) fill() {
+	defer func() {
+		b.mu.Lock()
+		b.running = false
+		if b.closed {
+			b.release()
+		}
+		b.mu.Unlock()
+	}()
+	empty := 0
+	for {
+		b.mu.Lock()
+		for b.n == len(b.data) && !b.closed {
+			b.cond.Wait()
+		}
+		if b.closed {
+			b.mu.Unlock()
+			return
+		}
+		// Read into the free space up to the end of the
+		// buffer, the reader only touches the data part.
+		tail := (b.head + b.n) % len(b.data)
+		end := tail + len(b.data) - b.n
+		if end > len(b.data) {
+			end = len(b.data)
+		}
+		b.mu.Unlock()
+
+		n, err := b.in.Read(b.data[tail:end])
+		if n == 0 && err == nil {
+			empty++
+			if empty < maxEmptyReads {
+				continue
+			}
+			err = io.ErrNoProgress
+		}
+		empty = 0
+
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return
+		}
+		b.n += n
+		if b.n > b.peak {
+			b.peak = b.n
+		}
+		if n > 0 && b.n == len(b.data) {
+			b.full++
+		}
+		b.err = err
+		marks := b.marks
+		b.cond.Broadcast()
+		b.mu.Unlock()
+		marks.add(n)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (b *readAhead) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b.mu.Lock()
+	for b.n == 0 && b.err == nil && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		b.mu.Unlock()
+		return 0, ErrClosed
+	}
+	if b.n == 0 {
+		err := b.err
+		b.reported = true
+		b.mu.Unlock()
+		return 0, err
+	}
+	end := b.head + b.n
+	if end > len(b.data) {
+		end = len(b.data)
+	}
+	n := copy(p, b.data[b.head:end])
+	b.head = (b.head + n) % len(b.data)
+	b.n -= n
+	marks := b.marks
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	marks.add(-n)
+	return n, nil
+}
@@ -0,0 +1,177 @@
+package iorate
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An endless source of the bytes 0, 1, ..., 255, 0, 1, ...
+type counting struct {
+	next byte
+}
+
+func (c *counting) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = c.next
+		c.next++
+	}
+	return len(b), nil
+}
+
+func TestBufferedDiscardWhileFilling(t *testing.T) {
+	r := NewBufferedReader(&counting{}, 0, 1000)
+	defer r.Close()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				r.Discard()
+				// Without preemption, as under js and wasip1,
+				// a busy loop would starve the reader.
+				runtime.Gosched()
+			}
+		}
+	}()
+
+	buf := make([]byte, 300)
+	for i := 0; i < 2000; i++ {
+		n, err := r.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Discarding skips data, but whatever a read returns
+		// must come from the source in order.
+		for j := 1; j < n; j++ {
+			if buf[j] != buf[j-1]+1 {
+				close(done)
+				wg.Wait()
+				t.Fatalf("read %d: byte %d is %d after %d", i, j, buf[j], buf[j-1])
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+	if s := r.Buffered(); s.Buffered < 0 || s.Buffered > s.Size {
+		t.Errorf("buffer holds %d bytes of %d", s.Buffered, s.Size)
+	}
+}
+
+func TestBufferedCloseUnblocksSource(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewBufferedReader(pr, 0, 0)
+
+	closed := make(chan error)
+	go func() {
+		// Give the goroutine time to block in the pipe's Read.
+		time.Sleep(10 * time.Millisecond)
+		closed <- r.Close()
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close hung on a source blocked in Read")
+	}
+	if _, err := pw.Write([]byte("late")); err != io.ErrClosedPipe {
+		t.Errorf("writing to the closed source gave %v, want io.ErrClosedPipe", err)
+	}
+}
+
+func TestBufferedClosePendingErrorOnce(t *testing.T) {
+	fail := errors.New("source failed")
+	src := io.MultiReader(bytes.NewReader([]byte("data")), &failing{fail})
+	r := NewBufferedReader(src, 0, 0)
+
+	// Wait for the goroutine to hit the error.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.ahead.mu.Lock()
+		err := r.ahead.err
+		r.ahead.mu.Unlock()
+		if err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the source's error never reached the buffer")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := r.Close(); err != fail {
+		t.Errorf("first Close returned %v, want %v", err, fail)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("second Close returned %v, want nil", err)
+	}
+}
+
+func TestBufferedCloseAfterErrorRead(t *testing.T) {
+	fail := errors.New("source failed")
+	r := NewBufferedReader(&failing{fail}, 0, 0)
+	if _, err := r.Read(make([]byte, 10)); err != fail {
+		t.Fatalf("Read returned %v, want %v", err, fail)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Close returned %v after Read had returned the error", err)
+	}
+}
+
+func TestBufferedCloseReleasesBuffer(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewBufferedReader(pr, 0, 0)
+	b := r.ahead
+	r.Close()
+
+	// The goroutine may still be on its way out of the pipe's Read,
+	// the buffer goes back once it's done.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		running, data := b.running, b.data
+		b.mu.Unlock()
+		if !running {
+			if data != nil {
+				t.Error("the buffer wasn't released after Close")
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the goroutine kept running after Close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBufferedReadAfterClose(t *testing.T) {
+	r := NewBufferedReader(&counting{}, 0, 0)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 10)); err != ErrClosed {
+		t.Errorf("Read after Close returned %v, want ErrClosed", err)
+	}
+}
+
+// A reader that always fails.
+type failing struct {
+	err error
+}
+
+func (f *failing) Read([]byte) (int, error) {
+	return 0, f.err
+}
@@ -0,0 +1,136 @@
+package iorate
+
+/*
+	Certification checks the limiter's work independently of the
+	slices. Every reservation is recorded when its holder is let go,
+	and every refund when it's made, and the bytes recorded over the
+	last second are compared with what the rate allows in a second.
+	The slices already keep to the rate, so a violation means that
+	something has gone wrong: the clock jumped, a sleep returned
+	early, or an overdraft went further than it should.
+
+	A second at the rate is allowed plus one slice, because a
+	reservation made at the very end of a slice and one made at the
+	start of the next are both legitimate.
+
+	The window starts over whenever the rate changes. Bytes passed at
+	a higher rate, during a boost or before SetRate lowered it, were
+	within the rate of their time and would otherwise be taken for a
+	violation of the new one.
+*/
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Length of the window the achieved rate is checked over.
+const certifyWindow = time.Second
+
+// Violation describes a limiter found passing more than its rate.
+type Violation struct {
+	// The limiter's rate and the rate it passed over the window
+	Rate, Achieved Rate
+	// The time the violation was found
+	At time.Time
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("iorate: rate violation: %d B/s passed, %d B/s allowed", v.Achieved, v.Rate)
+}
+
+// Makes the limiter check its achieved rate over every second against
+// its rate and call 'onViolation' whenever it's above by more than
+// 'tolerance', a fraction like 0.01 for 1%. A nil 'onViolation' makes
+// the limiter panic with the *Violation instead, which is what tests
+// would want. A negative tolerance turns checking off. Operations
+// scheduled with ReserveVector are not checked.
+func (l *Limiter) SetCertify(tolerance float64, onViolation func(*Violation)) {
+	var c *certifier
+	if tolerance >= 0 {
+		c = new(certifier)
+		c.tolerance = tolerance
+		c.onViolation = onViolation
+	}
+	l.mu.Lock()
+	l.cert = c
+	l.mu.Unlock()
+}
+
+// Checks the bytes passed in a sliding window.
+type certifier struct {
+	mu          sync.Mutex
+	tolerance   float64
+	onViolation func(*Violation)
+	// Recorded changes within the window and their sum
+	events []certEvent
+	sum    int64
+}
+
+type certEvent struct {
+	at time.Time
+	n  int64
+}
+
+// Records the reservation as passed on every level that is certified.
+func (l *Limiter) certify(r reservation) {
+	lim := l
+	for res := &r; res != nil; res = res.up {
+		lim.mu.Lock()
+		c := lim.cert
+		rate, slice := lim.rate, lim.perSlice
+		lim.mu.Unlock()
+		if c != nil && slice > 0 {
+			now := time.Now()
+			c.add(res.n, now)
+			c.check(rate, slice, now)
+		}
+		lim = lim.parent
+	}
+}
+
+// Records 'n' bytes, which may be negative for a refund.
+func (c *certifier) add(n int, now time.Time) {
+	if n == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.events = append(c.events, certEvent{now, int64(n)})
+	c.sum += int64(n)
+	c.mu.Unlock()
+}
+
+// Forgets the recorded changes, for a new rate.
+func (c *certifier) reset() {
+	c.mu.Lock()
+	c.events = nil
+	c.sum = 0
+	c.mu.Unlock()
+}
+
+// Reports a violation if the bytes in the window are more than the
+// rate and the slice allow.
+func (c *certifier) check(rate Rate, perSlice int, now time.Time) {
+	c.mu.Lock()
+	i := 0
+	for i < len(c.events) && now.Sub(c.events[i].at) > certifyWindow {
+		c.sum -= c.events[i].n
+		i++
+	}
+	c.events = c.events[i:]
+	allowed := float64(int64(rate)*int64(certifyWindow/time.Second)+int64(perSlice)) * (1 + c.tolerance)
+	var v *Violation
+	if float64(c.sum) > allowed {
+		v = &Violation{rate, Rate(c.sum), now}
+	}
+	f := c.onViolation
+	c.mu.Unlock()
+	if v == nil {
+		return
+	}
+	if f == nil {
+		panic(v)
+	}
+	f(v)
+}
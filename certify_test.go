@@ -0,0 +1,72 @@
+package iorate
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestCertifyAfterBoost(t *testing.T) {
+	l := NewLimiter(10 * KBps)
+	var violations []*Violation
+	l.SetCertify(0, func(v *Violation) {
+		violations = append(violations, v)
+	})
+	l.Boost(100*KBps, 500*time.Millisecond)
+
+	w := l.Writer(io.Discard)
+	buf := make([]byte, 1024)
+	start := time.Now()
+	for time.Since(start) < 800*time.Millisecond {
+		if _, err := w.Write(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(violations) > 0 {
+		t.Fatalf("got %d violations, the first %v", len(violations), violations[0])
+	}
+}
+
+func TestCertifyCountsShrunkReservation(t *testing.T) {
+	parent := NewLimiter(1 * KBps)
+	parent.SetInitialBudget(StartFull)
+	child := parent.Child("c", 100*KBps)
+	child.SetInitialBudget(StartFull)
+	child.SetCertify(0, nil)
+
+	r, err := child.reserve(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.n >= 1000 {
+		t.Fatalf("reserved %d bytes, want the parent to cut it down", r.n)
+	}
+	child.wait(r)
+	child.release(r, 0)
+
+	c := child.cert
+	c.mu.Lock()
+	sum := c.sum
+	c.mu.Unlock()
+	if sum != int64(r.n) {
+		t.Errorf("certifier counted %d bytes, want %d", sum, r.n)
+	}
+}
+
+func TestCertifyIgnoresCancel(t *testing.T) {
+	l := NewLimiter(100 * KBps)
+	l.SetInitialBudget(StartFull)
+	l.SetCertify(0, nil)
+	r, err := l.reserve(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.cancel(r)
+
+	l.cert.mu.Lock()
+	sum, events := l.cert.sum, len(l.cert.events)
+	l.cert.mu.Unlock()
+	if sum != 0 || events != 0 {
+		t.Errorf("certifier has %d events summing to %d, want none", events, sum)
+	}
+}
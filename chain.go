@@ -0,0 +1,203 @@
+package iorate
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Wrapper adds some behaviour on top of a reader or a writer.
+// Wrappers are composed with Chain.
+type Wrapper interface {
+	WrapReader(in io.Reader) io.Reader
+	WrapWriter(out io.Writer) io.Writer
+}
+
+// A wrapper made of two functions.
+type wrapper struct {
+	reader func(io.Reader) io.Reader
+	writer func(io.Writer) io.Writer
+}
+
+func (t wrapper) WrapReader(in io.Reader) io.Reader {
+	return t.reader(in)
+}
+
+func (t wrapper) WrapWriter(out io.Writer) io.Writer {
+	return t.writer(out)
+}
+
+// Returns a wrapper that applies the given wrappers in order, so the
+// first one is the closest to the wrapped stream and the last one is
+// what the application reads from or writes to. For example, with
+//
+//	w := iorate.Chain(iorate.Meter(&wire), iorate.Limit(iorate.MBps)).WrapWriter(conn)
+//
+// the writes to 'w' are limited first and then counted on their way
+// to 'conn'.
+func Chain(wrappers ...Wrapper) Wrapper {
+	return wrapper{
+		func(in io.Reader) io.Reader {
+			for _, w := range wrappers {
+				in = w.WrapReader(in)
+			}
+			return in
+		},
+		func(out io.Writer) io.Writer {
+			for _, w := range wrappers {
+				out = w.WrapWriter(out)
+			}
+			return out
+		},
+	}
+}
+
+// Returns a wrapper limiting each wrapped stream to 'maxSpeed' bytes
+// per second.
+func Limit(maxSpeed Rate) Wrapper {
+	return wrapper{
+		func(in io.Reader) io.Reader { return NewReader(in, maxSpeed) },
+		func(out io.Writer) io.Writer { return NewWriter(out, maxSpeed) },
+	}
+}
+
+// Returns a wrapper limiting all wrapped streams with a shared limiter.
+func Share(l *Limiter) Wrapper {
+	return wrapper{
+		func(in io.Reader) io.Reader { return l.Reader(in) },
+		func(out io.Writer) io.Writer { return l.Writer(out) },
+	}
+}
+
+// Returns a reader middleware limiting each reader it wraps to
+// 'maxSpeed' bytes per second, for frameworks that compose functions
+// of this kind. For readers sharing a budget, use Share(l).WrapReader.
+func ReaderMiddleware(maxSpeed Rate) func(io.Reader) io.Reader {
+	return Limit(maxSpeed).WrapReader
+}
+
+// Returns a writer middleware limiting each writer it wraps to
+// 'maxSpeed' bytes per second. For writers sharing a budget, use
+// Share(l).WrapWriter.
+func WriterMiddleware(maxSpeed Rate) func(io.Writer) io.Writer {
+	return Limit(maxSpeed).WrapWriter
+}
+
+// Counter is a byte count that can be updated concurrently.
+type Counter struct {
+	n int64
+}
+
+// Returns the number of bytes counted so far.
+func (c *Counter) Bytes() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+func (c *Counter) add(n int) {
+	atomic.AddInt64(&c.n, int64(n))
+}
+
+// Returns a wrapper that adds the bytes passing through it to 'c'.
+func Meter(c *Counter) Wrapper {
+	return wrapper{
+		func(in io.Reader) io.Reader {
+			return &funcReader{in, func(b []byte) (int, error) {
+				n, err := in.Read(b)
+				c.add(n)
+				return n, err
+			}}
+		},
+		func(out io.Writer) io.Writer {
+			return &funcWriter{out, func(b []byte) (int, error) {
+				n, err := out.Write(b)
+				c.add(n)
+				return n, err
+			}}
+		},
+	}
+}
+
+// Returns a wrapper that delays every read and write by 'd'.
+func Latency(d time.Duration) Wrapper {
+	return wrapper{
+		func(in io.Reader) io.Reader {
+			return &funcReader{in, func(b []byte) (int, error) {
+				sleep(d)
+				return in.Read(b)
+			}}
+		},
+		func(out io.Writer) io.Writer {
+			return &funcWriter{out, func(b []byte) (int, error) {
+				sleep(d)
+				return out.Write(b)
+			}}
+		},
+	}
+}
+
+// Returns a wrapper that lets 'after' bytes through each wrapped
+// stream and then fails all reads and writes with 'err'.
+func Fault(after int64, err error) Wrapper {
+	return wrapper{
+		func(in io.Reader) io.Reader {
+			left := after
+			return &funcReader{in, func(b []byte) (int, error) {
+				if left <= 0 {
+					return 0, err
+				}
+				if int64(len(b)) > left {
+					b = b[:left]
+				}
+				n, rerr := in.Read(b)
+				left -= int64(n)
+				return n, rerr
+			}}
+		},
+		func(out io.Writer) io.Writer {
+			left := after
+			return &funcWriter{out, func(b []byte) (int, error) {
+				if int64(len(b)) <= left {
+					n, werr := out.Write(b)
+					left -= int64(n)
+					return n, werr
+				}
+				n, werr := out.Write(b[:left])
+				left -= int64(n)
+				if werr != nil {
+					return n, werr
+				}
+				return n, err
+			}}
+		},
+	}
+}
+
+// A wrapper of a reader made of a function.
+type funcReader struct {
+	in   io.Reader
+	read func(b []byte) (int, error)
+}
+
+func (t *funcReader) Read(b []byte) (int, error) {
+	return t.read(b)
+}
+
+// Returns the underlying reader.
+func (t *funcReader) Unwrap() io.Reader {
+	return t.in
+}
+
+// A wrapper of a writer made of a function.
+type funcWriter struct {
+	out   io.Writer
+	write func(b []byte) (int, error)
+}
+
+func (t *funcWriter) Write(b []byte) (int, error) {
+	return t.write(b)
+}
+
+// Returns the underlying writer.
+func (t *funcWriter) Unwrap() io.Writer {
+	return t.out
+}
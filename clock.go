@@ -0,0 +1,75 @@
+package iorate
+
+/*
+	time.Sleep never returns early, but it routinely returns late: by
+	tens of microseconds on an idle laptop, by a millisecond or more in
+	a busy container. Since every time slice starts with a sleep, the
+	lateness adds up and the achieved rate ends up below the limit.
+
+	To compensate, sleep asks the runtime for a bit less than it needs.
+	The amount, 'margin', is the expected lateness. It is seeded by a few
+	short sleeps the first time it's needed and then follows the error
+	observed on every sleep, so it adapts to whatever host the program
+	happens to run on.
+*/
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of sleeps done to seed the margin.
+const calibrationRounds = 5
+
+var (
+	calibration sync.Once
+	// Expected time.Sleep lateness in nanoseconds.
+	margin int64
+)
+
+// Sleeps for about 'd' and returns the time actually spent sleeping.
+func sleep(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	if !compensate {
+		start := time.Now()
+		time.Sleep(d)
+		return time.Since(start)
+	}
+	calibration.Do(calibrate)
+
+	request := d - time.Duration(atomic.LoadInt64(&margin))
+	if request < d/2 {
+		request = d / 2
+	}
+	start := time.Now()
+	time.Sleep(request)
+	elapsed := time.Since(start)
+	observe(elapsed - request)
+	return elapsed
+}
+
+func calibrate() {
+	for i := 0; i < calibrationRounds; i++ {
+		start := time.Now()
+		time.Sleep(time.Millisecond)
+		observe(time.Since(start) - time.Millisecond)
+	}
+}
+
+// Updates the margin with a newly observed lateness as an
+// exponentially weighted moving average.
+func observe(late time.Duration) {
+	if late < 0 {
+		late = 0
+	}
+	for {
+		old := atomic.LoadInt64(&margin)
+		next := old + (int64(late)-old)/8
+		if atomic.CompareAndSwapInt64(&margin, old, next) {
+			return
+		}
+	}
+}
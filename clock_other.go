@@ -0,0 +1,11 @@
+//go:build !js && !wasip1
+
+package iorate
+
+import "time"
+
+// Shortest time slice a limiter may have.
+const minGap = time.Millisecond
+
+// Whether sleep compensates for the timer's lateness.
+const compensate = true
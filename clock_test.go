@@ -0,0 +1,55 @@
+package iorate
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSetMaxGapClampsToMinGap(t *testing.T) {
+	l := NewLimiter(100 * KBps)
+	l.SetMaxGap(minGap / 4)
+	l.mu.Lock()
+	slice, perSlice := l.slice, l.perSlice
+	l.mu.Unlock()
+	if slice != minGap {
+		t.Errorf("slice is %v, want %v", slice, minGap)
+	}
+	if want := sliceSize(100*KBps, minGap); perSlice != want {
+		t.Errorf("slice budget is %d, want %d", perSlice, want)
+	}
+}
+
+func TestSleepReturnsElapsed(t *testing.T) {
+	d := 2 * minGap
+	before := atomic.LoadInt64(&margin)
+	start := time.Now()
+	got := sleep(d)
+	total := time.Since(start)
+	if got > total {
+		t.Errorf("sleep reported %v, but only %v passed", got, total)
+	}
+	if compensate {
+		// The margin may cut the request down to a half.
+		if got < d/2 {
+			t.Errorf("slept %v, want at least %v", got, d/2)
+		}
+		return
+	}
+	// Plain sleeps are never early and leave the margin alone.
+	if got < d {
+		t.Errorf("slept %v, want at least %v", got, d)
+	}
+	if after := atomic.LoadInt64(&margin); after != before {
+		t.Errorf("margin changed from %d to %d", before, after)
+	}
+}
+
+func TestSleepNothing(t *testing.T) {
+	if got := sleep(0); got != 0 {
+		t.Errorf("sleep(0) took %v", got)
+	}
+	if got := sleep(-time.Second); got != 0 {
+		t.Errorf("sleep(-1s) took %v", got)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build js || wasip1
+
+package iorate
+
+/*
+	Browsers clamp timers to about 4ms and coarsen the clock they give
+	to programs, and WASI runtimes vary just as much. Slices shorter
+	than a timer tick would turn every slice into a stall, and lateness
+	measured with a coarse clock is mostly noise, so here slices are
+	kept at a tick or more and sleeps are taken as they are.
+*/
+
+import "time"
+
+// Shortest time slice a limiter may have.
+const minGap = 4 * time.Millisecond
+
+// Whether sleep compensates for the timer's lateness.
+const compensate = false
@@ -0,0 +1,94 @@
+package iorate
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of samples taken by ClockCheck.
+const clockCheckRounds = 20
+
+// ClockReport describes the timing quality of the host.
+type ClockReport struct {
+	// The shortest time a sleep actually takes
+	SleepResolution time.Duration
+	// The smallest step observed between two clock readings
+	ClockStep time.Duration
+	// Whether the wall clock never went backwards while the
+	// monotonic clock went forwards during the check. The limiter
+	// paces on the monotonic clock, but quota periods and
+	// resumption tokens go by the wall clock.
+	Monotonic bool
+}
+
+// Measures the host's sleep resolution and checks the clock. It takes
+// a few milliseconds.
+func ClockCheck() ClockReport {
+	r := ClockReport{Monotonic: true}
+
+	for i := 0; i < clockCheckRounds; i++ {
+		start := time.Now()
+		time.Sleep(time.Microsecond)
+		d := time.Since(start)
+		if r.SleepResolution == 0 || d < r.SleepResolution {
+			r.SleepResolution = d
+		}
+	}
+
+	// Sub on two readings from time.Now uses the monotonic clock,
+	// which can't go backwards, so the wall readings are compared
+	// separately.
+	prev := time.Now()
+	for i := 0; i < clockCheckRounds; {
+		now := time.Now()
+		d := now.Sub(prev)
+		if now.Round(0).Before(prev.Round(0)) {
+			r.Monotonic = false
+		}
+		if d > 0 {
+			if r.ClockStep == 0 || d < r.ClockStep {
+				r.ClockStep = d
+			}
+			i++
+		}
+		prev = now
+	}
+	return r
+}
+
+var (
+	hostClockOnce sync.Once
+	hostClock     ClockReport
+)
+
+// Returns the report on the host's clock, checking it the first time.
+func hostClockReport() ClockReport {
+	hostClockOnce.Do(func() {
+		hostClock = ClockCheck()
+	})
+	return hostClock
+}
+
+// Logs a warning if the rate can't be kept accurately with slices of
+// length 'dt', either because the slices can't carry it in whole
+// bytes or because the host's sleeps are too coarse for the slices.
+// Does nothing without a logger, so that the clock isn't checked for
+// nothing.
+func checkRate(maxSpeed Rate, dt time.Duration) {
+	if maxSpeed <= 0 || !hasLogger() {
+		return
+	}
+
+	actual := Rate(int64(sliceSize(maxSpeed, dt)) * int64(time.Second) / int64(dt))
+	if diff := actual - maxSpeed; diff*10 > maxSpeed || -diff*10 > maxSpeed {
+		logf("rate %d B/s will be %d B/s with %v slices", maxSpeed, actual, dt)
+	}
+
+	clock := hostClockReport()
+	if clock.SleepResolution*10 > dt {
+		logf("sleep resolution of %v is too coarse for %v slices, rates will be lower than set", clock.SleepResolution, dt)
+	}
+	if !clock.Monotonic {
+		logf("the wall clock went backwards, quota periods and resumption tokens may be off")
+	}
+}
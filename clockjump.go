@@ -0,0 +1,90 @@
+package iorate
+
+/*
+	Time values from time.Now carry both a wall clock reading and a
+	monotonic one. The limiter schedules on the monotonic clock, so
+	setting the system time doesn't disturb it, but not everything it
+	compares has a monotonic reading: times restored from resumption
+	tokens only have the wall clock. And a suspended machine is
+	another matter: on Linux and macOS the monotonic clock stops while
+	the machine sleeps, on Windows it keeps going.
+
+	So before every reservation the limiter compares how far each
+	clock has moved since the last one. When they disagree by more
+	than a second, the wall clock has been set or the machine has been
+	asleep. A slice that has only a wall clock reading, because it was
+	restored from a token, is then no longer where it seemed, and if
+	it ended up in the future it's brought back to the current time.
+	Slices and cursors with monotonic readings stay as they are: if
+	they're in the future, that's budget already handed out, and
+	starting afresh would let it out a second time.
+
+	A long gap between reservations by itself is not a jump, limiters
+	are often idle for long. Where the monotonic clock counts a
+	suspend, waking up looks just like that, and the ordinary limits
+	on catching up after idle time apply.
+*/
+
+import "time"
+
+// Disagreement between the clocks taken for a jump.
+const maxClockSkew = time.Second
+
+// ClockJump describes a jump of the clock noticed by a limiter.
+type ClockJump struct {
+	// Time the jump was noticed
+	At time.Time
+	// How much further the wall clock has moved than the
+	// monotonic clock, negative if it was set back
+	Skew time.Duration
+	// Time since the limiter's previous reservation
+	Gap time.Duration
+}
+
+// Sets the function to call when the limiter notices the wall clock
+// and the monotonic clock disagree, because the clock has been set or
+// the machine woke up from sleep. A nil function removes the
+// handler. The jumps are also logged.
+func (l *Limiter) SetClockJumpHandler(f func(ClockJump)) {
+	l.mu.Lock()
+	l.onClockJump = f
+	l.mu.Unlock()
+}
+
+// Checks the clocks since the last reservation and, if they have
+// jumped, fixes up the pacing and tells about it.
+func (l *Limiter) checkClock() {
+	now := time.Now()
+	l.mu.Lock()
+	last := l.seen
+	l.seen = now
+	if last.IsZero() {
+		l.mu.Unlock()
+		return
+	}
+	gap := now.Sub(last)
+	skew := now.Round(0).Sub(last.Round(0)) - gap
+	if skew <= maxClockSkew && skew >= -maxClockSkew {
+		l.mu.Unlock()
+		return
+	}
+	l.rebase(now)
+	f := l.onClockJump
+	l.mu.Unlock()
+
+	logf("clock jump of %v after %v", skew, gap)
+	if f != nil {
+		f(ClockJump{now, skew, gap})
+	}
+}
+
+// Brings a slice known only by the wall clock back to 'now' if it's in
+// the future after a jump. Must be called with the lock held.
+func (l *Limiter) rebase(now time.Time) {
+	// Round(0) strips the monotonic reading, so a time that has
+	// none stays the same.
+	wallOnly := l.start == l.start.Round(0)
+	if wallOnly && l.start.After(now) {
+		l.start, l.used = now, 0
+	}
+}
@@ -0,0 +1,50 @@
+package iorate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleIsNotClockJump(t *testing.T) {
+	l := NewLimiter(10 * KBps)
+	jumps := 0
+	l.SetClockJumpHandler(func(ClockJump) {
+		jumps++
+	})
+	l.mu.Lock()
+	l.seen = time.Now().Add(-2 * time.Minute)
+	l.mu.Unlock()
+
+	l.checkClock()
+	if jumps > 0 {
+		t.Errorf("two idle minutes taken for a clock jump")
+	}
+}
+
+func TestClockJumpKeepsQueue(t *testing.T) {
+	l := NewLimiter(10 * KBps)
+	now := time.Now()
+	queued := now.Add(500 * time.Millisecond)
+	l.mu.Lock()
+	l.start, l.used = queued, 100
+	l.cursor = queued
+	l.rebase(now)
+	start, used, cursor := l.start, l.used, l.cursor
+	l.mu.Unlock()
+	if start != queued || used != 100 || cursor != queued {
+		t.Errorf("queued budget moved to %v, %d used, cursor %v", start.Sub(now), used, cursor.Sub(now))
+	}
+}
+
+func TestClockJumpRebasesRestoredSlice(t *testing.T) {
+	l := NewLimiter(10 * KBps)
+	now := time.Now()
+	l.mu.Lock()
+	l.start, l.used = now.Add(time.Hour).Round(0), 100
+	l.rebase(now)
+	start, used := l.start, l.used
+	l.mu.Unlock()
+	if start != now || used != 0 {
+		t.Errorf("restored slice is %v ahead with %d used, want it at now", start.Sub(now), used)
+	}
+}
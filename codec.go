@@ -0,0 +1,58 @@
+package iorate
+
+import "io"
+
+/*
+	When a limiter is combined with a compression stage the limit can
+	apply to either of two points: the compressed bytes that actually go
+	over the wire, or the uncompressed bytes the application produces
+	and consumes. Putting the wrapper on the wrong side of the codec is
+	an easy mistake, so the helpers below take the codec constructor
+	and do the placement according to an explicit Side.
+*/
+
+// Side selects which bytes of a compressed stream are charged.
+type Side int
+
+const (
+	// Wire charges the encoded bytes, that is what the
+	// underlying reader or writer sees.
+	Wire Side = iota
+	// Logical charges the decoded bytes, that is what the
+	// application sees.
+	Logical
+)
+
+// Returns a writer that encodes data using 'encode' and writes it to
+// 'out', limiting the rate of the bytes on the given side. Closing the
+// returned writer closes the encoder, but not 'out'.
+func NewCodecWriter(out io.Writer, maxSpeed Rate, side Side, encode func(io.Writer) io.WriteCloser) io.WriteCloser {
+	if side == Wire {
+		return encode(NewWriter(out, maxSpeed))
+	}
+	enc := encode(out)
+	return &codecWriter{NewWriter(enc, maxSpeed), enc}
+}
+
+// Returns a reader that decodes data from 'in' using 'decode',
+// limiting the rate of the bytes on the given side.
+func NewCodecReader(in io.Reader, maxSpeed Rate, side Side, decode func(io.Reader) (io.Reader, error)) (io.Reader, error) {
+	if side == Wire {
+		return decode(NewReader(in, maxSpeed))
+	}
+	dec, err := decode(in)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(dec, maxSpeed), nil
+}
+
+// A limited writer in front of an encoder that has to be closed.
+type codecWriter struct {
+	io.Writer
+	enc io.Closer
+}
+
+func (t *codecWriter) Close() error {
+	return t.enc.Close()
+}
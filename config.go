@@ -0,0 +1,203 @@
+package iorate
+
+/*
+	A config describes a tree of limiters: the rates of a limiter and
+	its children by name, and so on down. Applying a config to a live
+	tree changes only what differs. Limiters whose rate is the same
+	keep their slices as they are, so the streams on them don't notice
+	anything.
+
+	A Watcher reads a config from a provider function whenever it's
+	asked to, on SIGHUP and periodically, and applies it if the
+	content has changed.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config is the shape of a limiter tree. In JSON it looks like
+//
+//	{"rate": 10485760, "children": {"uploads": {"rate": 1048576}}}
+//
+// with rates in bytes per second.
+type Config struct {
+	Rate     Rate              `json:"rate"`
+	Children map[string]Config `json:"children,omitempty"`
+}
+
+// Reads a config in JSON.
+func ParseConfig(r io.Reader) (Config, error) {
+	var c Config
+	err := json.NewDecoder(r).Decode(&c)
+	return c, err
+}
+
+// Makes the limiter and its descendants match 'c'. Rates that differ
+// are changed, children missing from the limiter are added and
+// children added by an earlier Apply that are missing from 'c' are
+// removed. A removed child's streams keep working and drawing on this
+// limiter, but the child is gone from Stats and Find. Children made
+// by Child directly are left alone.
+func (l *Limiter) Apply(c Config) {
+	l.mu.Lock()
+	rate := l.base
+	children := append([]*Limiter(nil), l.children...)
+	l.mu.Unlock()
+	if rate != c.Rate {
+		l.SetRate(c.Rate)
+	}
+
+	seen := make(map[string]bool)
+	for _, child := range children {
+		if !child.configured {
+			continue
+		}
+		cc, ok := c.Children[child.name]
+		if !ok || seen[child.name] {
+			child.detach()
+			continue
+		}
+		seen[child.name] = true
+		child.Apply(cc)
+	}
+
+	var names []string
+	for name := range c.Children {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cc := c.Children[name]
+		child := l.Child(name, cc.Rate)
+		child.configured = true
+		child.Apply(cc)
+	}
+}
+
+// Returns the descendant at the given path of child names, or nil if
+// there is none.
+func (l *Limiter) Find(path ...string) *Limiter {
+	for _, name := range path {
+		var next *Limiter
+		l.mu.Lock()
+		for _, c := range l.children {
+			if c.name == name {
+				next = c
+				break
+			}
+		}
+		l.mu.Unlock()
+		if next == nil {
+			return nil
+		}
+		l = next
+	}
+	return l
+}
+
+// Returns a config provider reading the file at 'path'.
+func ConfigFile(path string) func() (io.Reader, error) {
+	return func() (io.Reader, error) {
+		return os.Open(path)
+	}
+}
+
+// Watcher keeps a limiter tree in line with a config that may change.
+type Watcher struct {
+	root *Limiter
+	load func() (io.Reader, error)
+
+	// The config last applied
+	mu   sync.Mutex
+	last []byte
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// Applies the config returned by 'load' to 'l' and returns a watcher
+// that applies it again on SIGHUP, where there is one, and every
+// 'interval', if 'interval' is not zero, whenever it has changed.
+// Readers returned by 'load' that are also io.Closers are closed after
+// reading. Errors after the first load are logged and leave the tree
+// as it was.
+func Watch(l *Limiter, load func() (io.Reader, error), interval time.Duration) (*Watcher, error) {
+	w := new(Watcher)
+	w.root = l
+	w.load = load
+	w.stop = make(chan struct{})
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	hup := make(chan os.Signal, 1)
+	if hangup != nil {
+		signal.Notify(hup, hangup)
+	}
+	go w.run(hup, interval)
+	return w, nil
+}
+
+// Reads the config and applies it if it has changed.
+func (w *Watcher) Reload() error {
+	r, err := w.load()
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if c, ok := r.(io.Closer); ok {
+		c.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.last != nil && bytes.Equal(data, w.last) {
+		return nil
+	}
+	c, err := ParseConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	w.root.Apply(c)
+	w.last = data
+	return nil
+}
+
+// Stops watching. The tree stays as it is.
+func (w *Watcher) Close() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+func (w *Watcher) run(hup chan os.Signal, interval time.Duration) {
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-hup:
+		case <-tick:
+		}
+		if err := w.Reload(); err != nil {
+			logf("config reload failed: %v", err)
+		}
+	}
+}
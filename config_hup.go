@@ -0,0 +1,11 @@
+//go:build !js && !wasip1 && !plan9
+
+package iorate
+
+import (
+	"os"
+	"syscall"
+)
+
+// The signal that makes watchers reload their configs.
+var hangup os.Signal = syscall.SIGHUP
@@ -0,0 +1,8 @@
+//go:build js || wasip1 || plan9
+
+package iorate
+
+import "os"
+
+// There's no hangup signal here, watchers reload periodically only.
+var hangup os.Signal
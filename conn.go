@@ -0,0 +1,130 @@
+package iorate
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// ErrNotSupported is returned by options that the platform or the
+// wrapped connection doesn't support.
+var ErrNotSupported = errors.New("iorate: not supported")
+
+// A connection with limited rates.
+type conn struct {
+	net.Conn
+	r *reader
+	w *writer
+	// Delays added to reads and writes, if any
+	readDelay, writeDelay *delay
+}
+
+// A delay with a random variation.
+type delay struct {
+	d, jitter time.Duration
+}
+
+// Returns a connection whose reads and writes are limited to
+// 'readSpeed' and 'writeSpeed' bytes per second.
+func NewConn(c net.Conn, readSpeed, writeSpeed Rate) *conn {
+	return newConn(c, NewLimiter(readSpeed), NewLimiter(writeSpeed))
+}
+
+func newConn(c net.Conn, in, out *Limiter) *conn {
+	t := new(conn)
+	t.Conn = c
+	t.r = in.Reader(c)
+	t.w = out.Writer(c)
+	return t
+}
+
+// Closes the connection. If its limiters are children of shared ones,
+// they are removed from the parents.
+func (t *conn) Close() error {
+	t.r.limiter.detach()
+	t.w.limiter.detach()
+	return t.Conn.Close()
+}
+
+// Implements the io.Read function.
+func (t *conn) Read(b []byte) (int, error) {
+	n, err := t.r.Read(b)
+	if n > 0 {
+		t.readDelay.wait()
+	}
+	return n, err
+}
+
+// Implements the io.Write function.
+func (t *conn) Write(b []byte) (int, error) {
+	t.writeDelay.wait()
+	return t.w.Write(b)
+}
+
+// Writes the buffers as vectored writes, see writer.WriteBuffers.
+func (t *conn) WriteBuffers(v *net.Buffers) (int64, error) {
+	t.writeDelay.wait()
+	return t.w.WriteBuffers(v)
+}
+
+// Makes every read that gets data return it 'd' later, give or take a
+// random 'jitter', as if it had arrived that much later. Together with
+// SetWriteLatency, this emulates the two directions' shares of a round
+// trip. The delay is per read, not per byte, so it's meant for request
+// and response exchanges rather than for bulk streams. Zero 'd' and
+// 'jitter' remove the delay.
+func (t *conn) SetReadLatency(d, jitter time.Duration) {
+	t.readDelay = newDelay(d, jitter)
+}
+
+// Delays every write by 'd', give or take a random 'jitter', before
+// it's passed on, see SetReadLatency.
+func (t *conn) SetWriteLatency(d, jitter time.Duration) {
+	t.writeDelay = newDelay(d, jitter)
+}
+
+func newDelay(d, jitter time.Duration) *delay {
+	if d <= 0 && jitter <= 0 {
+		return nil
+	}
+	return &delay{d, jitter}
+}
+
+// Sleeps for the delay with a new random variation.
+func (p *delay) wait() {
+	if p == nil {
+		return
+	}
+	d := p.d
+	if p.jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*p.jitter))) - p.jitter
+	}
+	sleep(d)
+}
+
+// Sets the hooks to call when the connection waits.
+func (t *conn) SetTrace(trace *Trace) {
+	t.r.SetTrace(trace)
+	t.w.SetTrace(trace)
+}
+
+// Limits the amount of written data the kernel keeps unsent to 'n'
+// bytes, using the TCP_NOTSENT_LOWAT socket option. Without it, paced
+// writes still pile up in the socket buffer whenever the network is
+// slower than the pacing, and a latency-sensitive message written
+// after them waits behind all of them. If 'n' is zero or less, the
+// limit is one time slice worth of data at the write rate. Returns
+// ErrNotSupported if the platform or the wrapped connection doesn't
+// have the option.
+func (t *conn) SetNotSentLowat(n int) error {
+	if n <= 0 {
+		t.w.limiter.mu.Lock()
+		n = t.w.limiter.perSlice
+		t.w.limiter.mu.Unlock()
+	}
+	if n <= 0 {
+		return nil
+	}
+	return setNotSentLowat(t.Conn, n)
+}
@@ -0,0 +1,45 @@
+package iorate
+
+import "io"
+
+// LimitedSource is a reader annotated with a rate for Copy, so that
+// the limits of a pipeline can be given as data. Reading from it
+// directly is not limited.
+type LimitedSource struct {
+	R    io.Reader
+	Rate Rate
+}
+
+// LimitedSink is a writer annotated with a rate for Copy. Writing to
+// it directly is not limited.
+type LimitedSink struct {
+	W    io.Writer
+	Rate Rate
+}
+
+func (s LimitedSource) Read(b []byte) (int, error) {
+	return s.R.Read(b)
+}
+
+func (s LimitedSink) Write(b []byte) (int, error) {
+	return s.W.Write(b)
+}
+
+// Copies from 'src' to 'dst' like io.Copy, limiting the transfer to
+// the rate of 'src' if it's a LimitedSource and of 'dst' if it's a
+// LimitedSink, or the lower of the two if both are. The bytes are
+// accounted for once, as with Relay.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	rate := Rate(0)
+	if s, ok := src.(LimitedSource); ok {
+		src = s.R
+		rate = s.Rate
+	}
+	if d, ok := dst.(LimitedSink); ok {
+		dst = d.W
+		if d.Rate > 0 && (rate <= 0 || d.Rate < rate) {
+			rate = d.Rate
+		}
+	}
+	return Relay(dst, src, NewLimiter(rate))
+}
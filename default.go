@@ -0,0 +1,23 @@
+package iorate
+
+import "io"
+
+// The limiter used by WrapReader and WrapWriter.
+var defaultLimiter = NewLimiter(0)
+
+// Sets the rate of the process-wide limiter shared by all readers and
+// writers returned by WrapReader and WrapWriter, including the ones
+// already returned. Until it is called, the rate is not limited.
+func SetDefaultLimit(maxSpeed Rate) {
+	defaultLimiter.SetRate(maxSpeed)
+}
+
+// Returns a reader that draws on the process-wide limiter.
+func WrapReader(in io.Reader) *reader {
+	return defaultLimiter.Reader(in)
+}
+
+// Returns a writer that draws on the process-wide limiter.
+func WrapWriter(out io.Writer) *writer {
+	return defaultLimiter.Writer(out)
+}
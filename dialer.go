@@ -0,0 +1,54 @@
+package iorate
+
+import (
+	"context"
+	"net"
+)
+
+// Dialer makes limited connections. Its DialContext method has the
+// signature client libraries accept for custom dialers: the hooks of
+// http.Transport, database/sql drivers, Redis clients and the like,
+// so that bulk reads or replication can be throttled on the
+// connection level.
+//
+//	d := &iorate.Dialer{ReadRate: 1 * iorate.MBps, Read: iorate.NewLimiter(10 * iorate.MBps)}
+//	transport := &http.Transport{DialContext: d.DialContext}
+//
+// gives every connection 1 MBps for reading, but no more than 10 MBps
+// for all of them together.
+type Dialer struct {
+	// Dials the underlying connections. If nil, a zero net.Dialer
+	// is used.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Rates of each connection, zero for no limit
+	ReadRate, WriteRate Rate
+
+	// Limiters shared by all the connections, and possibly by other
+	// dialers, for an aggregate cap on the pool. Each connection's
+	// limiter becomes their child for as long as the connection is
+	// open, named after the remote address. May be nil.
+	Read, Write *Limiter
+}
+
+// Dials a connection and wraps it according to the dialer's settings.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	dial := d.Dial
+	if dial == nil {
+		dial = new(net.Dialer).DialContext
+	}
+	c, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	name := c.RemoteAddr().String()
+	return newConn(c, d.limiter(d.Read, name, d.ReadRate), d.limiter(d.Write, name, d.WriteRate)), nil
+}
+
+// Returns a limiter for a new connection.
+func (d *Dialer) limiter(pool *Limiter, name string, maxSpeed Rate) *Limiter {
+	if pool == nil {
+		return NewLimiter(maxSpeed)
+	}
+	return pool.Child(name, maxSpeed)
+}
@@ -0,0 +1,134 @@
+package iorate
+
+/*
+	A group moves its writers in phases, one time slice each. In every
+	phase each member sends up to its slice's worth of data and then
+	waits at a barrier. When the last member arrives, the next phase is
+	scheduled one slice after the start of the current one and everybody
+	is released at that moment, so the members' sends are aligned no
+	matter how long each of them took.
+*/
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Group releases its writers in lockstep, for load generation
+// experiments where timing between streams has to be reproducible.
+// A member that isn't writing holds the others back until it writes
+// again or leaves the group with Close.
+type Group struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	members int
+	arrived int
+	phase   uint64
+	// Start of the current phase, zero until the first write
+	start time.Time
+}
+
+// Returns an empty group.
+func NewGroup() *Group {
+	g := new(Group)
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// A member of a group.
+type groupWriter struct {
+	out      io.Writer
+	group    *Group
+	perSlice int
+	left     bool
+}
+
+// Returns a writer limited to 'maxSpeed' bytes per second that joins
+// the group. It has to be closed to leave the group.
+func (g *Group) Writer(out io.Writer, maxSpeed Rate) *groupWriter {
+	t := new(groupWriter)
+	t.out = out
+	t.group = g
+	t.perSlice = sliceSize(maxSpeed, time.Duration(tau)*time.Millisecond)
+	g.mu.Lock()
+	g.members++
+	g.mu.Unlock()
+	return t
+}
+
+// Implements the io.Write function.
+func (t *groupWriter) Write(b []byte) (n int, err error) {
+	total := len(b)
+	t.group.begin()
+	for n < total {
+		end := total
+		if t.perSlice > 0 && end-n > t.perSlice {
+			end = n + t.perSlice
+		}
+		sent, err := t.out.Write(b[n:end])
+		n += sent
+		t.group.arrive()
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Leaves the group, so that the others no longer wait for the writer.
+// The underlying writer isn't closed.
+func (t *groupWriter) Close() error {
+	g := t.group
+	g.mu.Lock()
+	if !t.left {
+		t.left = true
+		g.members--
+		if g.members > 0 && g.arrived >= g.members {
+			g.advance()
+		}
+	}
+	g.mu.Unlock()
+	return nil
+}
+
+// Waits for the current phase to start.
+func (g *Group) begin() {
+	g.mu.Lock()
+	if g.start.IsZero() {
+		g.start = time.Now()
+	}
+	start := g.start
+	g.mu.Unlock()
+	sleep(time.Until(start))
+}
+
+// Waits for the other members to finish the current phase and then
+// for the next phase to start.
+func (g *Group) arrive() {
+	g.mu.Lock()
+	g.arrived++
+	if g.arrived >= g.members {
+		g.advance()
+	} else {
+		phase := g.phase
+		for g.phase == phase {
+			g.cond.Wait()
+		}
+	}
+	start := g.start
+	g.mu.Unlock()
+	sleep(time.Until(start))
+}
+
+// Moves on to the next phase. Must be called with the lock held.
+func (g *Group) advance() {
+	dt := time.Duration(tau) * time.Millisecond
+	g.arrived = 0
+	g.phase++
+	g.start = g.start.Add(dt)
+	if now := time.Now(); g.start.Before(now) {
+		g.start = now
+	}
+	g.cond.Broadcast()
+}
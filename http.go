@@ -0,0 +1,67 @@
+package iorate
+
+import (
+	"context"
+	"net/http"
+)
+
+type rateKey struct{}
+
+// Returns a copy of 'ctx' carrying a rate that overrides the one
+// given to Middleware for the request served with this context.
+// A handler placed before the middleware, for example one that looks
+// up the user's plan, uses it as:
+//
+//	r = r.WithContext(iorate.WithRate(r.Context(), 2 * iorate.Mbps))
+//
+// A rate of zero or less disables limiting for the request.
+func WithRate(ctx context.Context, rate Rate) context.Context {
+	return context.WithValue(ctx, rateKey{}, rate)
+}
+
+// Returns the rate set with WithRate and whether there was one.
+func ContextRate(ctx context.Context) (Rate, bool) {
+	rate, ok := ctx.Value(rateKey{}).(Rate)
+	return rate, ok
+}
+
+// Returns an HTTP middleware that limits the rate at which response
+// bodies are written to 'maxSpeed', or to the rate found in the
+// request's context, if any.
+func Middleware(maxSpeed Rate) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rate := maxSpeed
+			if override, ok := ContextRate(r.Context()); ok {
+				rate = override
+			}
+			if rate > 0 {
+				w = &responseWriter{w, NewWriter(w, rate)}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// A response writer whose body writes go through a limited writer.
+type responseWriter struct {
+	http.ResponseWriter
+	body *writer
+}
+
+func (t *responseWriter) Write(b []byte) (int, error) {
+	return t.body.Write(b)
+}
+
+// Gives http.ResponseController access to the original writer.
+func (t *responseWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// Flushes the original writer, if it can be flushed, so that streaming
+// handlers checking for http.Flusher keep working under a limit.
+func (t *responseWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
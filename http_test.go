@@ -0,0 +1,26 @@
+package iorate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareFlushes(t *testing.T) {
+	h := Middleware(100 * KBps)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("limited response writer is not an http.Flusher")
+		}
+		w.Write([]byte("chunk"))
+		f.Flush()
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !rec.Flushed {
+		t.Error("the response wasn't flushed")
+	}
+	if got := rec.Body.String(); got != "chunk" {
+		t.Errorf("got body %q, want %q", got, "chunk")
+	}
+}
@@ -18,6 +18,7 @@ package iorate
 
 import (
 	"io"
+	"net"
 	"time"
 )
 
@@ -42,93 +43,238 @@ const (
 )
 
 type writer struct {
-	out         io.Writer
-	maxSendSize int
+	out     io.Writer
+	limiter *Limiter
+	trace   *Trace
+	marks   *watermarks
+	retries RetryPolicy
+	label   string
 }
 
 type reader struct {
-	in          io.Reader
-	maxReadSize int
+	in      io.Reader
+	limiter *Limiter
+	trace   *Trace
+	empty   *emptyReads
+	label   string
+	// Moving average of the buffer sizes passed to Read
+	chunk int
+}
+
+// How a reader handles reads that return no data and no error.
+type emptyReads struct {
+	max     int
+	backoff time.Duration
 }
 
 // Returns a writer limited to 'maxSpeed' bytes per second.
 func NewWriter(out io.Writer, maxSpeed Rate) *writer {
-	t := new(writer)
-	t.out = out
-	t.maxSendSize = int(int64(maxSpeed) * int64(tau) / 1000)
-	return t
+	return NewLimiter(maxSpeed).Writer(out)
 }
 
 // Returns a reader limited to 'maxSpeed' bytes per second.
 func NewReader(in io.Reader, maxSpeed Rate) *reader {
-	t := new(reader)
-	t.in = in
-	t.maxReadSize = int(int64(maxSpeed) * int64(tau) / 1000)
-	return t
+	return NewLimiter(maxSpeed).Reader(in)
+}
+
+// Sets the hooks to call when the writer waits. A nil trace
+// removes the hooks.
+func (t *writer) SetTrace(trace *Trace) {
+	t.trace = trace
+}
+
+// Sets the hooks to call when the reader waits. A nil trace
+// removes the hooks.
+func (t *reader) SetTrace(trace *Trace) {
+	t.trace = trace
+}
+
+// Makes the reader retry reads that return no data and no error
+// instead of passing them on, since the budget they take can't always
+// be returned and a caller retrying them at once just spins. Before
+// each retry the reader sleeps, starting with 'backoff' and doubling
+// it up to the slice length. After 'max' empty reads in a row, the
+// read fails with io.ErrNoProgress. Zero 'max' means retrying until
+// there is data or an error.
+func (t *reader) SetEmptyReads(max int, backoff time.Duration) {
+	t.empty = &emptyReads{max, backoff}
+}
+
+// Returns a channel that is closed once the reader can read without
+// waiting, see Limiter.Ready.
+func (t *reader) Ready() <-chan struct{} {
+	return t.limiter.Ready()
 }
 
-// Implements the io.Read function.
+// Implements the io.Read function. Like most readers, it returns
+// whatever a single read from the underlying reader gave instead of
+// waiting for 'b' to fill up. Waiting would stall synchronous sources
+// like io.Pipe, where the writer might not write more until the reader
+// has dealt with what it has already got.
+//
+// A read near the end of a time slice isn't cut down to the few bytes
+// left in the slice if callers usually ask for more. Instead it gets
+// the usual amount, up to a slice's budget, and the next slice gets
+// less. This way a consumer isn't fed tiny pieces it has to come
+// back for, and one that reads small buffers still gets them filled
+// whenever there is data.
 func (t *reader) Read(b []byte) (n int, err error) {
-	max := cap(b)
+	if len(b) == 0 {
+		return 0, nil
+	}
+	waited := time.Duration(0)
+	defer func() {
+		t.trace.readWait(waited)
+		err = labelError(t.label, "read", err)
+	}()
 
-	// Maximum receive size we can do in 'tau' time
-	readSize := max
-	if readSize > t.maxReadSize {
-		readSize = t.maxReadSize
+	if t.chunk == 0 {
+		t.chunk = len(b)
+	} else {
+		t.chunk += (len(b) - t.chunk) / 4
 	}
 
-	dt := time.Duration(tau) * time.Millisecond
-	err = nil
-	n = 0
-	end := 0
+	empty := 0
+	var backoff time.Duration
+	for {
+		r, err := t.limiter.reserveMin(len(b), t.chunk)
+		if err != nil {
+			return 0, err
+		}
+		waited += t.limiter.wait(r)
 
-	for n < max {
-		time.Sleep(dt)
+		n, err = t.in.Read(b[:r.n])
+		t.limiter.release(r, r.n-n)
+		if n > 0 || err != nil || t.empty == nil {
+			return n, err
+		}
 
-		end = n + readSize
-		if end > max {
-			end = max
+		empty++
+		if t.empty.max > 0 && empty >= t.empty.max {
+			return 0, io.ErrNoProgress
 		}
-		read, err := t.in.Read(b[n:end])
-		n += read
-		if err != nil {
-			break
+		if empty == 1 {
+			backoff = t.empty.backoff
+		} else {
+			backoff *= 2
+		}
+		if dt := time.Duration(tau) * time.Millisecond; backoff > dt {
+			backoff = dt
 		}
+		sleep(backoff)
 	}
-	return n, err
 }
 
 // Implements the io.Write function.
 func (t *writer) Write(b []byte) (n int, err error) {
 	total := len(b)
-
-	// Maximum send size we can do in 'tau' time
-	sendSize := total
-	if sendSize > t.maxSendSize {
-		sendSize = t.maxSendSize
-	}
-
-	dt := time.Duration(tau) * time.Millisecond
-	err = nil
+	waited := time.Duration(0)
+	defer func() {
+		t.trace.writeWait(waited)
+		err = labelError(t.label, "write", err)
+	}()
 
 	// Bounds of the data portion being sent
 	pos := 0
 	end := 0
+	t.marks.add(total)
+	defer func() {
+		t.marks.add(pos - total)
+	}()
 
 	for pos < total {
-		time.Sleep(dt)
-
-		end = pos + sendSize
-		if end > total {
-			end = total
+		r, err := t.limiter.reserve(total - pos)
+		if err != nil {
+			return pos, err
 		}
+		waited += t.limiter.wait(r)
 
+		end = pos + r.n
 		sent, err := t.out.Write(b[pos:end])
 		pos += sent
+		t.marks.add(-sent)
+		if err != nil {
+			t.limiter.release(r, r.n-sent)
+			return pos, err
+		}
+	}
+
+	return pos, nil
+}
+
+// Writes the contents of 'v' to the underlying writer, consuming 'v'
+// the same way net.Buffers.WriteTo does. Each time slice gets at most
+// the slice's budget worth of buffers, which are passed on as
+// net.Buffers so that a writer supporting vectored writes (a TCP
+// connection, for example) still gets them as a single writev call.
+//
+// net.Buffers.WriteTo only finds vectored writers in the net package
+// itself, so 'bufs.WriteTo(w)' on a limited writer falls back to a
+// Write per buffer. Callers wanting writev must call w.WriteBuffers
+// themselves.
+func (t *writer) WriteBuffers(v *net.Buffers) (n int64, err error) {
+	waited := time.Duration(0)
+	defer func() {
+		t.trace.writeWait(waited)
+		err = labelError(t.label, "write", err)
+	}()
+	t.marks.add(buffersLen(*v))
+	defer func() {
+		t.marks.add(-buffersLen(*v))
+	}()
+
+	for len(*v) > 0 {
+		r, err := t.limiter.reserve(buffersLen(*v))
+		if err != nil {
+			return n, err
+		}
+		waited += t.limiter.wait(r)
+
+		// Collect buffers for this slice into a fresh slice so that
+		// WriteTo consuming it doesn't disturb 'v'.
+		var chunk net.Buffers
+		size := 0
+		for _, b := range *v {
+			if size == r.n {
+				break
+			}
+			if size+len(b) > r.n {
+				b = b[:r.n-size]
+			}
+			chunk = append(chunk, b)
+			size += len(b)
+		}
+
+		sent, err := chunk.WriteTo(t.out)
+		n += sent
+		consume(v, sent)
+		t.marks.add(-int(sent))
 		if err != nil {
-			break
+			t.limiter.release(r, r.n-int(sent))
+			return n, err
 		}
 	}
+	return n, nil
+}
+
+// Returns the total length of the buffers.
+func buffersLen(v net.Buffers) int {
+	n := 0
+	for _, b := range v {
+		n += len(b)
+	}
+	return n
+}
 
-	return pos, err
+// Removes the first 'n' bytes from 'v'.
+func consume(v *net.Buffers, n int64) {
+	for len(*v) > 0 {
+		ln0 := int64(len((*v)[0]))
+		if ln0 > n {
+			(*v)[0] = (*v)[0][n:]
+			return
+		}
+		n -= ln0
+		*v = (*v)[1:]
+	}
 }
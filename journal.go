@@ -0,0 +1,49 @@
+package iorate
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Record is a journal entry about a finished transfer.
+type Record struct {
+	Label string    `json:"label"`
+	Start time.Time `json:"start"`
+	// Time from the start to the end of the transfer
+	Duration time.Duration `json:"duration"`
+	Bytes    int64         `json:"bytes"`
+	// The rate the transfer was limited to at the end and the
+	// average rate it achieved
+	Rate     Rate `json:"rate"`
+	Achieved Rate `json:"achieved"`
+	// Bytes charged to the quota, zero if there was none
+	Quota int64 `json:"quota"`
+	// Why the transfer was cut off, empty if it completed
+	Error string `json:"error,omitempty"`
+}
+
+// Journal receives a record of every finished transfer, for auditing
+// bandwidth usage. A manager writes to it when transfers are
+// forgotten or closed.
+type Journal interface {
+	Record(Record) error
+}
+
+// A journal writing a JSON object per line.
+type jsonJournal struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// Returns a journal writing each record to 'w' as a line of JSON.
+func NewJSONJournal(w io.Writer) Journal {
+	return &jsonJournal{enc: json.NewEncoder(w)}
+}
+
+func (j *jsonJournal) Record(r Record) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.enc.Encode(r)
+}
@@ -0,0 +1,621 @@
+package iorate
+
+/*
+	A Limiter is the time slice budget from the package comment taken
+	out of a single reader or writer so that several of them can share
+	it. Each slice of length tau carries L*tau bytes. The slices are
+	tau = 100 ms long by default, but a limiter can use shorter ones.
+
+	Instead of sleeping and then spending, callers reserve their portion
+	first. A reservation is a number of bytes and the start of the slice
+	they belong to. When the current slice is used up, the limiter moves
+	on to the next one, which may well be in the future, and the caller
+	holding a reservation in it has to wait until then. Because slices
+	are handed out in order, waiting callers are served in the order
+	they came.
+*/
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a rate budget that can be shared by any number of
+// readers and writers. The sum of their rates stays within the
+// limiter's rate.
+type Limiter struct {
+	mu sync.Mutex
+
+	// Position in a hierarchy of limiters and whether the limiter
+	// was made by Apply
+	name       string
+	parent     *Limiter
+	children   []*Limiter
+	configured bool
+
+	// Bytes reserved so far
+	meter meter
+
+	// The rate, the length of a slice and the bytes it carries,
+	// which is zero if there is no limit
+	rate     Rate
+	slice    time.Duration
+	perSlice int
+
+	// The rate as set, before any boosts, and the boosts in effect
+	base   Rate
+	boosts []*boost
+
+	// Allowance for longer periods, if any, and the bytes charged
+	// to it by this limiter
+	quota     *Quota
+	quotaUsed int64
+
+	// Start of the current slice and the bytes already reserved in it
+	start time.Time
+	used  int
+
+	// Sequence number of the last resumption token made or resumed
+	tokenSeq uint64
+
+	// Number of callers sleeping until their reservations
+	waiters int
+
+	// Load shedding limits, zero if not set
+	maxWaiters int
+	maxDelay   time.Duration
+
+	// Fraction of the first slice available at once
+	initial float64
+
+	// Size under which operations are protected from long delays
+	// and the longest delay they get, zero if not set
+	sloSize  int
+	sloDelay time.Duration
+
+	// The error all reservations fail with, like ErrClosed once
+	// the limiter's manager has closed it
+	err error
+
+	// Checker of the achieved rate, if certification is on
+	cert *certifier
+
+	// The pacing strategy and, for strategies other than fixed
+	// slices, the time the budget is spent up to
+	strategy Strategy
+	cursor   time.Time
+	// How far the Deadline schedule may fall behind, zero for a slice
+	catchUp time.Duration
+
+	// Time of the last reservation, to notice the clock jumping,
+	// and the handler to tell about it
+	seen        time.Time
+	onClockJump func(ClockJump)
+}
+
+// OverloadError is returned by reads and writes on a limiter whose
+// waiter count or queue delay limit would be exceeded.
+type OverloadError struct {
+	// Number of operations already waiting
+	Waiters int
+	// Time the rejected operation would have had to wait
+	Delay time.Duration
+}
+
+func (e *OverloadError) Error() string {
+	return fmt.Sprintf("iorate: limiter overloaded (%d waiting, %v delay)", e.Waiters, e.Delay)
+}
+
+// A portion of a limiter's budget.
+type reservation struct {
+	// Number of bytes
+	n int
+	// Start of the slice the bytes belong to and the time the
+	// caller may go ahead, which is earlier for protected operations
+	start, wake time.Time
+	// Whether the reservation is counted as a waiter
+	waiting bool
+	// The matching reservation on the parent limiter, if any
+	up *reservation
+}
+
+// Returns a limiter with the rate of 'maxSpeed' bytes per second.
+// A rate of zero or less means no limit.
+func NewLimiter(maxSpeed Rate) *Limiter {
+	l := new(Limiter)
+	l.slice = time.Duration(tau) * time.Millisecond
+	checkRate(maxSpeed, l.slice)
+	l.rate = maxSpeed
+	l.base = maxSpeed
+	l.perSlice = sliceSize(maxSpeed, l.slice)
+	return l
+}
+
+// Returns the number of bytes a slice of length 'dt' carries at the
+// given rate.
+func sliceSize(maxSpeed Rate, dt time.Duration) int {
+	if maxSpeed <= 0 {
+		return 0
+	}
+	q := int(int64(maxSpeed) * int64(dt) / int64(time.Second))
+	if q < 1 {
+		q = 1
+	}
+	return q
+}
+
+// Returns a writer that draws on the limiter's budget.
+func (l *Limiter) Writer(out io.Writer) *writer {
+	t := new(writer)
+	t.out = out
+	t.limiter = l
+	return t
+}
+
+// Returns a reader that draws on the limiter's budget.
+func (l *Limiter) Reader(in io.Reader) *reader {
+	t := new(reader)
+	t.in = in
+	t.limiter = l
+	return t
+}
+
+// Changes the limiter's rate. Readers and writers already using the
+// limiter switch to the new rate from the next time slice.
+func (l *Limiter) SetRate(maxSpeed Rate) {
+	l.mu.Lock()
+	dt := l.slice
+	l.mu.Unlock()
+	checkRate(maxSpeed, dt)
+	l.setRate(maxSpeed)
+}
+
+// Changes the rate without checking it, for rates that are adjusted
+// automatically and often.
+func (l *Limiter) setRate(maxSpeed Rate) {
+	l.mu.Lock()
+	l.base = maxSpeed
+	l.applyRate()
+	l.mu.Unlock()
+}
+
+// Initial budgets for SetInitialBudget.
+const (
+	StartEmpty = 0.0
+	StartFull  = 1.0
+)
+
+// Sets the part of a slice's budget the limiter has before it's first
+// used. With StartEmpty, which is the default, even the first byte
+// waits for a slice to pass, so the pacing is strict from the start.
+// With StartFull, a slice's worth goes through at once. Fractions in
+// between allow a smaller initial burst. Has no effect once the
+// limiter has been used.
+func (l *Limiter) SetInitialBudget(fraction float64) {
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	l.mu.Lock()
+	l.initial = fraction
+	l.mu.Unlock()
+}
+
+// Makes the limiter hand out its budget in slices no longer than 'd',
+// for streams like audio or telemetry where the pauses between bursts
+// matter even when the average rate is right. A continuous transfer
+// then never has a gap longer than 'd' between two writes to the
+// underlying stream, give or take the timer's accuracy, at the cost
+// of smaller writes and more wakeups. Zero restores the default
+// slice length. Gaps under a millisecond, or under a timer tick on
+// WebAssembly, are rounded up.
+func (l *Limiter) SetMaxGap(d time.Duration) {
+	if d <= 0 || d > time.Duration(tau)*time.Millisecond {
+		d = time.Duration(tau) * time.Millisecond
+	}
+	if d < minGap {
+		d = minGap
+	}
+	l.mu.Lock()
+	l.slice = d
+	l.perSlice = sliceSize(l.rate, d)
+	rate := l.rate
+	l.mu.Unlock()
+	checkRate(rate, d)
+}
+
+// Makes the limiter's readers and writers also draw on the quota 'q',
+// which may be shared with other limiters. Once the quota is used up,
+// reads and writes fail with ErrQuotaExceeded until the next period.
+// A nil quota removes the restriction.
+func (l *Limiter) SetQuota(q *Quota) {
+	l.mu.Lock()
+	l.quota = q
+	l.mu.Unlock()
+}
+
+// Sets the maximum number of operations allowed to wait for the
+// budget at the same time. Operations that would have to wait beyond
+// that fail with an *OverloadError. Zero means no limit.
+func (l *Limiter) SetMaxWaiters(n int) {
+	l.mu.Lock()
+	l.maxWaiters = n
+	l.mu.Unlock()
+}
+
+// Sets the maximum time an operation may wait for the budget.
+// Operations that would have to wait longer fail with an
+// *OverloadError. Zero means no limit.
+func (l *Limiter) SetMaxDelay(d time.Duration) {
+	l.mu.Lock()
+	l.maxDelay = d
+	l.mu.Unlock()
+}
+
+// Makes operations smaller than 'size' bytes wait no longer than 'd',
+// so that small control messages sharing a stream with bulk data
+// aren't stuck behind it. Such an operation is never split, and when
+// its slice is further away than 'd' it borrows the bytes from that
+// slice and goes ahead early. The borrowed bytes are still counted,
+// so the bulk transfers wait a bit longer instead and the average
+// rate doesn't change, unless the small operations alone go over it.
+// Parent limiters delay the operation as usual unless they have the
+// same setting. A size of zero turns the protection off.
+func (l *Limiter) SetLatencySLO(size int, d time.Duration) {
+	l.mu.Lock()
+	l.sloSize = size
+	l.sloDelay = d
+	l.mu.Unlock()
+}
+
+// Returns the earliest time a transfer of 'n' bytes could start
+// without queueing behind what has already been reserved, so that
+// cooperating batch jobs can stagger their work. If the limiter has a
+// quota that can't accommodate 'n' bytes in the current period, the
+// suggestion is the start of the next period. Nothing is reserved, so
+// the suggestion is only as good as the current load is stable.
+func (l *Limiter) Suggest(n int) time.Time {
+	t := l.suggest(n, time.Now())
+	if l.parent != nil {
+		if up := l.parent.Suggest(n); up.After(t) {
+			t = up
+		}
+	}
+	return t
+}
+
+// Returns the suggestion for this limiter alone.
+func (l *Limiter) suggest(n int, now time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.quota != nil && l.quota.Remaining() < int64(n) {
+		return l.quota.Renews()
+	}
+	if l.perSlice == 0 {
+		return now
+	}
+	if at, _ := l.next(now); at.After(now) {
+		return at
+	}
+	return now
+}
+
+// Returns a channel that is closed once the limiter has budget for
+// an immediate read or write, so that select-based code can wait for
+// it along with other events. It is a hint rather than a reservation:
+// another goroutine can take the budget first.
+func (l *Limiter) Ready() <-chan struct{} {
+	ch := make(chan struct{})
+	now := time.Now()
+	at := l.readyAt(now)
+	if !at.After(now) {
+		close(ch)
+		return ch
+	}
+	time.AfterFunc(at.Sub(now), func() {
+		close(ch)
+	})
+	return ch
+}
+
+// Returns the time the limiter and its ancestors will have budget.
+func (l *Limiter) readyAt(now time.Time) time.Time {
+	l.mu.Lock()
+	at := now
+	if l.perSlice > 0 {
+		at, _ = l.next(now)
+	}
+	quota := l.quota
+	l.mu.Unlock()
+
+	if quota != nil && quota.Remaining() == 0 {
+		if renews := quota.Renews(); renews.After(at) {
+			at = renews
+		}
+	}
+	if l.parent != nil {
+		if up := l.parent.readyAt(now); up.After(at) {
+			at = up
+		}
+	}
+	return at
+}
+
+// Reserves up to 'n' bytes on the limiter and its ancestors. The
+// reservation may be smaller than asked for, but not empty unless 'n'
+// is zero.
+func (l *Limiter) reserve(n int) (reservation, error) {
+	return l.reserveMin(n, 0)
+}
+
+// Works like reserve, but reserves at least 'min' bytes, up to a
+// slice's budget, even if the current slice has less left. The
+// difference is taken from the following slice.
+func (l *Limiter) reserveMin(n, min int) (reservation, error) {
+	r, err := l.reserveOwn(n, min)
+	if err != nil || l.parent == nil || r.n == 0 {
+		return r, err
+	}
+	up, err := l.parent.reserveMin(r.n, min)
+	if err != nil {
+		l.cancel(r)
+		return reservation{}, err
+	}
+	if up.n < r.n {
+		l.releaseOwn(r, r.n-up.n, false)
+		r.n = up.n
+	}
+	r.up = &up
+	return r, nil
+}
+
+// Reserves up to 'n' bytes, but at least 'min' if possible, on this
+// limiter alone.
+func (l *Limiter) reserveOwn(n, min int) (reservation, error) {
+	if n <= 0 {
+		return reservation{}, nil
+	}
+	l.checkClock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return reservation{}, l.err
+	}
+	now := time.Now()
+	if l.perSlice == 0 {
+		r, err := l.charge(reservation{n: n}, now)
+		l.meter.add(r.n, now)
+		return r, err
+	}
+	if l.strategy != FixedSlice {
+		return l.reservePaced(n, now)
+	}
+
+	start, used := l.next(now)
+	wake := start
+	protected := n < l.sloSize
+	if protected && start.Sub(now) > l.sloDelay {
+		wake = now.Add(l.sloDelay)
+	}
+	delay := wake.Sub(now)
+	waiting := delay > 0
+	if err := l.checkLoad(delay); err != nil {
+		return reservation{}, err
+	}
+
+	if !protected && n > l.perSlice-used {
+		if min > n {
+			min = n
+		}
+		if min > l.perSlice {
+			min = l.perSlice
+		}
+		n = l.perSlice - used
+		if n < min {
+			n = min
+		}
+	}
+	r, err := l.charge(reservation{n: n, start: start, wake: wake, waiting: waiting}, now)
+	if err != nil {
+		return r, err
+	}
+	if waiting {
+		l.waiters++
+	}
+	used += r.n
+	if used > l.perSlice {
+		// The slice has been overdrawn, move on to the slice that
+		// the overdraft reaches.
+		k := (used - 1) / l.perSlice
+		start = start.Add(time.Duration(k) * l.slice)
+		used -= k * l.perSlice
+	}
+	l.start, l.used = start, used
+	l.meter.add(r.n, now)
+	return r, nil
+}
+
+// Returns an *OverloadError if an operation can't wait for 'delay'.
+// Must be called with the lock held.
+func (l *Limiter) checkLoad(delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	if l.maxWaiters > 0 && l.waiters >= l.maxWaiters {
+		return &OverloadError{l.waiters, delay}
+	}
+	if l.maxDelay > 0 && delay > l.maxDelay {
+		return &OverloadError{l.waiters, delay}
+	}
+	return nil
+}
+
+// Returns the slice the next reserved byte goes to and the number of
+// bytes already reserved in it. Must be called with the lock held.
+// Under other strategies than fixed slices, returns the time the next
+// operation may go and zero.
+func (l *Limiter) next(now time.Time) (time.Time, int) {
+	if l.strategy != FixedSlice {
+		at, _ := l.pace(l.cursor, 0, now)
+		return at, 0
+	}
+	dt := l.slice
+	switch {
+	case l.start.IsZero() && l.initial > 0:
+		// Any initial budget lets at least a byte through.
+		budget := int(l.initial * float64(l.perSlice))
+		if budget < 1 && l.perSlice > 0 {
+			budget = 1
+		}
+		return now, l.perSlice - budget
+	case l.start.IsZero():
+		// Like the original writer, don't send anything before
+		// the first slice has passed.
+		return now.Add(dt), 0
+	case !now.Before(l.start.Add(dt)):
+		// The limiter has been idle, start a new slice now.
+		return now, 0
+	case l.used >= l.perSlice:
+		return l.start.Add(dt), 0
+	}
+	return l.start, l.used
+}
+
+// Charges the reservation to the quota, if there is one, shrinking
+// the reservation to what's left of the quota.
+func (l *Limiter) charge(r reservation, now time.Time) (reservation, error) {
+	if l.quota == nil {
+		return r, nil
+	}
+	r.n = l.quota.take(r.n, now)
+	if r.n == 0 {
+		return reservation{}, ErrQuotaExceeded
+	}
+	l.quotaUsed += int64(r.n)
+	return r, nil
+}
+
+// Reserves exactly 'n' bytes, which may take several slices, and
+// waits until the last of them. This is for data that can't be
+// split, like datagrams. Returns the time spent waiting.
+func (l *Limiter) take(n int) (time.Duration, error) {
+	waited := time.Duration(0)
+	for n > 0 {
+		r, err := l.reserve(n)
+		if err != nil {
+			return waited, err
+		}
+		waited += l.wait(r)
+		n -= r.n
+	}
+	return waited, nil
+}
+
+// Sleeps until the slices of the reservation on the limiter and its
+// ancestors have started and returns the time spent sleeping.
+func (l *Limiter) wait(r reservation) time.Duration {
+	at := time.Time{}
+	for res := &r; res != nil; res = res.up {
+		if res.waiting && res.wake.After(at) {
+			at = res.wake
+		}
+	}
+	slept := time.Duration(0)
+	if !at.IsZero() {
+		slept = sleep(time.Until(at))
+	}
+	l.done(r)
+	l.certify(r)
+	return slept
+}
+
+// Stops counting the reservation as a waiter.
+func (l *Limiter) done(r reservation) {
+	lim := l
+	for res := &r; res != nil; res = res.up {
+		if res.waiting {
+			lim.mu.Lock()
+			lim.waiters--
+			lim.mu.Unlock()
+		}
+		lim = lim.parent
+	}
+}
+
+// Gives up a reservation that hasn't been waited for.
+func (l *Limiter) cancel(r reservation) {
+	l.giveBack(r, r.n, false)
+	l.done(r)
+}
+
+// Returns 'unused' bytes of a reservation that has been waited for
+// back to the limiter and its ancestors.
+func (l *Limiter) release(r reservation, unused int) {
+	l.giveBack(r, unused, true)
+}
+
+// Returns 'unused' bytes of a reservation back to the limiter and its
+// ancestors. 'certified' tells whether the certifiers have counted the
+// reservation, which happens once it has been waited for.
+func (l *Limiter) giveBack(r reservation, unused int, certified bool) {
+	lim := l
+	for res := &r; res != nil; res = res.up {
+		lim.releaseOwn(*res, unused, certified)
+		lim = lim.parent
+	}
+}
+
+// Returns 'unused' bytes of a reservation back to this limiter's
+// budget. This is only possible while the slices the reservation has
+// reached are still current, but the quota gets them back in any
+// case. The certifier only hears of the refund if it has counted the
+// bytes.
+func (l *Limiter) releaseOwn(r reservation, unused int, certified bool) {
+	if unused <= 0 {
+		return
+	}
+	l.mu.Lock()
+	l.meter.add(-unused, time.Now())
+	if l.quota != nil {
+		l.quota.refund(unused)
+		l.quotaUsed -= int64(unused)
+	}
+	if l.cert != nil && certified {
+		l.cert.add(-unused, time.Now())
+	}
+	if l.perSlice > 0 && l.strategy != FixedSlice {
+		l.cursor = l.cursor.Add(-l.duration(unused))
+	} else if l.perSlice > 0 {
+		l.unreserve(r.start, unused)
+	}
+	l.mu.Unlock()
+}
+
+// Moves the budget back by 'unused' bytes of a reservation that
+// started in the slice 'from'. The reservation may have overdrawn its
+// slice and moved the limiter on to later ones, then these are given
+// back too. Does nothing if the current slice isn't a whole number of
+// slices after 'from', which means the limiter has been idle and
+// started afresh. Must be called with the lock held.
+func (l *Limiter) unreserve(from time.Time, unused int) {
+	d := l.start.Sub(from)
+	if d < 0 || d%l.slice != 0 {
+		return
+	}
+	total := int(d/l.slice)*l.perSlice + l.used - unused
+	if total < 0 {
+		total = 0
+	}
+	k := 0
+	if total > l.perSlice {
+		k = (total - 1) / l.perSlice
+	}
+	l.start = from.Add(time.Duration(k) * l.slice)
+	l.used = total - k*l.perSlice
+}
@@ -0,0 +1,228 @@
+package iorate
+
+/*
+	A linked pair is two connection endpoints joined by two one-way
+	queues. Writes go through a limited writer that appends copies of
+	the data to the queue, each stamped with the time it may be
+	delivered. Reads take data from the head of the queue once that
+	time has come.
+
+	Closing an endpoint is loss-free: everything it has written stays
+	in the queue, and the other endpoint gets EOF only after reading it.
+*/
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Profile describes a simulated link between two endpoints.
+type Profile struct {
+	// Rates from the first endpoint to the second and back,
+	// zero for no limit
+	Up, Down Rate
+	// One-way delay added to everything sent
+	Latency time.Duration
+}
+
+// Returns two connected in-memory endpoints, like net.Pipe, with the
+// traffic between them shaped according to the profile 'p'. Unlike
+// with net.Pipe, writes don't wait for the other side to read.
+func NewLinkedPair(p Profile) (net.Conn, net.Conn) {
+	ab := newQueue(p.Latency)
+	ba := newQueue(p.Latency)
+	a := &linkedConn{in: ba, out: ab}
+	b := &linkedConn{in: ab, out: ba}
+	a.w = NewWriter(writeFunc(a.push), p.Up)
+	b.w = NewWriter(writeFunc(b.push), p.Down)
+	return a, b
+}
+
+// An io.Writer made of a function.
+type writeFunc func(b []byte) (int, error)
+
+func (f writeFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
+
+// A piece of data in a queue.
+type chunk struct {
+	data []byte
+	at   time.Time
+}
+
+// One direction of a linked pair.
+type queue struct {
+	mu      sync.Mutex
+	latency time.Duration
+	chunks  []chunk
+	// Closed and replaced every time something changes
+	changed chan struct{}
+	// Whether the writing or the reading endpoint has been closed
+	wclosed, rclosed bool
+}
+
+func newQueue(latency time.Duration) *queue {
+	q := new(queue)
+	q.latency = latency
+	q.changed = make(chan struct{})
+	return q
+}
+
+// Wakes up everyone waiting for a change. Must be called with the
+// lock held.
+func (q *queue) broadcast() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// An endpoint of a linked pair.
+type linkedConn struct {
+	in, out *queue
+	w       *writer
+
+	mu                          sync.Mutex
+	readDeadline, writeDeadline time.Time
+}
+
+func (t *linkedConn) Read(b []byte) (int, error) {
+	q := t.in
+	for {
+		t.mu.Lock()
+		deadline := t.readDeadline
+		t.mu.Unlock()
+
+		q.mu.Lock()
+		if q.rclosed {
+			q.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		now := time.Now()
+		if !deadline.IsZero() && !now.Before(deadline) {
+			q.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		// Wait for the deadline, the next delivery or a change,
+		// whichever comes first.
+		wake := deadline
+		if len(q.chunks) > 0 {
+			head := &q.chunks[0]
+			if !now.Before(head.at) {
+				n := copy(b, head.data)
+				head.data = head.data[n:]
+				if len(head.data) == 0 {
+					q.chunks = q.chunks[1:]
+				}
+				q.mu.Unlock()
+				return n, nil
+			}
+			if wake.IsZero() || head.at.Before(wake) {
+				wake = head.at
+			}
+		} else if q.wclosed {
+			q.mu.Unlock()
+			return 0, io.EOF
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		if wake.IsZero() {
+			<-changed
+			continue
+		}
+		timer := time.NewTimer(time.Until(wake))
+		select {
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (t *linkedConn) Write(b []byte) (int, error) {
+	return t.w.Write(b)
+}
+
+// Queues the data passed by the limited writer.
+func (t *linkedConn) push(b []byte) (int, error) {
+	t.mu.Lock()
+	deadline := t.writeDeadline
+	t.mu.Unlock()
+
+	q := t.out
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch {
+	case q.wclosed:
+		return 0, net.ErrClosed
+	case q.rclosed:
+		return 0, io.ErrClosedPipe
+	case !deadline.IsZero() && !time.Now().Before(deadline):
+		return 0, os.ErrDeadlineExceeded
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	q.chunks = append(q.chunks, chunk{data, time.Now().Add(q.latency)})
+	q.broadcast()
+	return len(b), nil
+}
+
+// Closes the endpoint. The other endpoint can still read what has
+// been written before getting EOF, but its writes fail.
+func (t *linkedConn) Close() error {
+	for _, q := range []*queue{t.in, t.out} {
+		q.mu.Lock()
+		if q == t.in {
+			q.rclosed = true
+			q.chunks = nil
+		} else {
+			q.wclosed = true
+		}
+		q.broadcast()
+		q.mu.Unlock()
+	}
+	return nil
+}
+
+func (t *linkedConn) LocalAddr() net.Addr {
+	return linkedAddr{}
+}
+
+func (t *linkedConn) RemoteAddr() net.Addr {
+	return linkedAddr{}
+}
+
+func (t *linkedConn) SetDeadline(d time.Time) error {
+	t.SetWriteDeadline(d)
+	return t.SetReadDeadline(d)
+}
+
+func (t *linkedConn) SetReadDeadline(d time.Time) error {
+	t.mu.Lock()
+	t.readDeadline = d
+	t.mu.Unlock()
+	// Make a waiting Read look at the new deadline.
+	t.in.mu.Lock()
+	t.in.broadcast()
+	t.in.mu.Unlock()
+	return nil
+}
+
+// Sets the write deadline. Writes check it every time slice, so one
+// may return up to a slice after the deadline.
+func (t *linkedConn) SetWriteDeadline(d time.Time) error {
+	t.mu.Lock()
+	t.writeDeadline = d
+	t.mu.Unlock()
+	return nil
+}
+
+// The address of both endpoints of a linked pair.
+type linkedAddr struct{}
+
+func (linkedAddr) Network() string { return "linked" }
+func (linkedAddr) String() string  { return "linked" }
@@ -0,0 +1,142 @@
+// Generates synthetic traffic at a target rate following a pattern,
+// for network test tools.
+package loadgen
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/gaswelder/iorate"
+)
+
+// How often the generator looks at the pattern.
+const step = 100 * time.Millisecond
+
+// Pattern returns the target rate at time 't' since the start.
+// A rate of zero or less means silence.
+type Pattern func(t time.Duration) iorate.Rate
+
+// Returns a constant rate pattern.
+func CBR(rate iorate.Rate) Pattern {
+	return func(time.Duration) iorate.Rate {
+		return rate
+	}
+}
+
+// Returns a pattern alternating 'on' periods at 'rate' and 'off'
+// periods of silence. Without 'on' periods the pattern is silent, and
+// without 'off' periods it's constant.
+func Bursty(rate iorate.Rate, on, off time.Duration) Pattern {
+	if on <= 0 {
+		return CBR(0)
+	}
+	if off <= 0 {
+		return CBR(rate)
+	}
+	return func(t time.Duration) iorate.Rate {
+		if t%(on+off) < on {
+			return rate
+		}
+		return 0
+	}
+}
+
+// Returns a pattern going linearly from 'from' to 'to' over the given
+// time and staying at 'to' after that.
+func Ramp(from, to iorate.Rate, over time.Duration) Pattern {
+	return func(t time.Duration) iorate.Rate {
+		if t >= over {
+			return to
+		}
+		return from + iorate.Rate(float64(to-from)*float64(t)/float64(over))
+	}
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	// Bytes written
+	Bytes int64
+	// Time the run took
+	Duration time.Duration
+	// Average achieved rate
+	Rate iorate.Rate
+	// Average rate the pattern asked for
+	Target iorate.Rate
+}
+
+// Writes random data to 'w' following the pattern for the duration
+// 'd', or until the context is done or a write fails.
+func Run(ctx context.Context, w io.Writer, p Pattern, d time.Duration) (Report, error) {
+	// The limiter is made with the first rate the pattern asks
+	// for, a placeholder rate would only trigger warnings.
+	var l *iorate.Limiter
+	var out io.Writer
+	buf := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(buf)
+
+	var r Report
+	var target float64
+	// Bytes due but not yet written, including the fractions of a
+	// byte left over by earlier steps at low rates
+	var due float64
+	steps := 0
+	start := time.Now()
+	var err error
+	for {
+		elapsed := time.Since(start)
+		if elapsed >= d || ctx.Err() != nil {
+			break
+		}
+		rate := p(elapsed)
+		target += float64(rate)
+		steps++
+		if rate <= 0 {
+			time.Sleep(step)
+			continue
+		}
+		if l == nil {
+			l = iorate.NewLimiter(rate)
+			// Fixed slices carry whole bytes, which is far off
+			// the rate at a few bytes per second. The leaky
+			// bucket spaces the bytes at the exact rate.
+			l.SetStrategy(iorate.LeakyBucket)
+			out = l.Writer(w)
+		} else {
+			l.SetRate(rate)
+		}
+
+		// One step's worth of data at the current rate.
+		due += float64(rate) * step.Seconds()
+		left := int64(due)
+		due -= float64(left)
+		for left > 0 && err == nil {
+			chunk := buf
+			if int64(len(chunk)) > left {
+				chunk = chunk[:left]
+			}
+			var n int
+			n, err = out.Write(chunk)
+			r.Bytes += int64(n)
+			left -= int64(n)
+		}
+		if err != nil {
+			break
+		}
+		// Sleep out the rest of the step, so that a step with
+		// little or nothing due doesn't start the next one early.
+		if rest := elapsed + step - time.Since(start); rest > 0 {
+			time.Sleep(rest)
+		}
+	}
+
+	r.Duration = time.Since(start)
+	if secs := r.Duration.Seconds(); secs > 0 {
+		r.Rate = iorate.Rate(float64(r.Bytes) / secs)
+	}
+	if steps > 0 {
+		r.Target = iorate.Rate(target / float64(steps))
+	}
+	return r, err
+}
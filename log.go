@@ -0,0 +1,36 @@
+package iorate
+
+import "sync"
+
+// Logger receives the package's warnings. A *log.Logger will do.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+var (
+	loggerMu sync.Mutex
+	logger   Logger
+)
+
+// Sets the logger for the package's warnings. By default there is
+// none and nothing is logged.
+func SetLogger(l Logger) {
+	loggerMu.Lock()
+	logger = l
+	loggerMu.Unlock()
+}
+
+func logf(format string, v ...interface{}) {
+	loggerMu.Lock()
+	l := logger
+	loggerMu.Unlock()
+	if l != nil {
+		l.Printf("iorate: "+format, v...)
+	}
+}
+
+func hasLogger() bool {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	return logger != nil
+}
@@ -0,0 +1,57 @@
+package iorate
+
+import (
+	"io"
+	"sync"
+)
+
+// A reader paced by the rate of the data the application gets out of
+// it rather than the bytes read.
+type logicalReader struct {
+	*reader
+	target Rate
+
+	mu            sync.Mutex
+	wire, logical int64
+}
+
+// Returns a reader that aims at 'logicalSpeed' units of logical data
+// per second, where the logical data is whatever the application
+// reports with Consumed: decompressed bytes, records, messages. The
+// reader tracks the ratio between the bytes it reads and the reported
+// units and sets its byte rate accordingly. Until something is
+// reported, the byte rate is 'logicalSpeed'.
+func NewLogicalReader(in io.Reader, logicalSpeed Rate) *logicalReader {
+	t := new(logicalReader)
+	t.reader = NewReader(in, logicalSpeed)
+	t.target = logicalSpeed
+	return t
+}
+
+// Implements the io.Read function.
+func (t *logicalReader) Read(b []byte) (int, error) {
+	n, err := t.reader.Read(b)
+	t.mu.Lock()
+	t.wire += int64(n)
+	t.mu.Unlock()
+	return n, err
+}
+
+// Reports that 'n' units of logical data have been consumed and
+// updates the byte rate.
+func (t *logicalReader) Consumed(n int) {
+	t.mu.Lock()
+	t.logical += int64(n)
+	wire, logical := t.wire, t.logical
+	t.mu.Unlock()
+
+	if t.target <= 0 || wire == 0 || logical == 0 {
+		return
+	}
+	rate := Rate(float64(t.target) * float64(wire) / float64(logical))
+	if rate < 1 {
+		// Zero would mean no limit.
+		rate = 1
+	}
+	t.limiter.setRate(rate)
+}
@@ -0,0 +1,28 @@
+package iorate
+
+import (
+	"net"
+	"syscall"
+)
+
+// TCP_NOTSENT_LOWAT from linux/tcp.h
+const tcpNotSentLowat = 0x19
+
+func setNotSentLowat(c net.Conn, n int) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return ErrNotSupported
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpNotSentLowat, n)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package iorate
+
+import "net"
+
+func setNotSentLowat(c net.Conn, n int) error {
+	return ErrNotSupported
+}
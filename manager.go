@@ -0,0 +1,235 @@
+package iorate
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by reads and writes of transfers closed by
+// Manager.CloseAll.
+var ErrClosed = errors.New("iorate: transfer closed")
+
+// How often CloseAll checks whether the transfers have drained.
+const drainPoll = 10 * time.Millisecond
+
+// StreamKey identifies a logical transfer that may span several
+// connections, for example a download that is resumed after the
+// connection drops.
+type StreamKey string
+
+// Manager keeps a limiter per logical transfer, so that a reconnected
+// transfer continues with the state it had, including the budget it
+// has already taken and its quota usage, instead of starting afresh.
+type Manager struct {
+	mu       sync.Mutex
+	maxSpeed Rate
+	streams  map[StreamKey]*stream
+	journal  Journal
+	policy   WrapPolicy
+}
+
+// A transfer kept by a manager.
+type stream struct {
+	limiter *Limiter
+	created time.Time
+	// The streams passed to Reader and Writer that can be closed
+	closers []io.Closer
+}
+
+// StreamReport is the final account of a transfer closed by CloseAll.
+type StreamReport struct {
+	Key StreamKey
+	// Bytes passed, the time from the first use of the transfer to
+	// its closing and the average rate over that time
+	Bytes    int64
+	Duration time.Duration
+	Rate     Rate
+	// Nil if the transfer had drained, otherwise the reason it was
+	// cut off, which is the context's error
+	Err error
+}
+
+// Returns a manager whose transfers are each limited to 'maxSpeed'
+// bytes per second.
+func NewManager(maxSpeed Rate) *Manager {
+	m := new(Manager)
+	m.maxSpeed = maxSpeed
+	m.streams = make(map[StreamKey]*stream)
+	return m
+}
+
+// Returns the limiter of the transfer with the given key, creating it
+// on first use. Settings made on the limiter, such as a quota, stay
+// with the transfer.
+func (m *Manager) Limiter(key StreamKey) *Limiter {
+	return m.stream(key, nil).limiter
+}
+
+// Returns the transfer with the given key, creating it on first use,
+// and registers 'c' with it if it's an io.Closer.
+func (m *Manager) stream(key StreamKey, c interface{}) *stream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[key]
+	if !ok {
+		s = new(stream)
+		s.limiter = NewLimiter(m.maxSpeed)
+		s.created = time.Now()
+		m.streams[key] = s
+	}
+	if c, ok := c.(io.Closer); ok {
+		s.closers = append(s.closers, c)
+	}
+	return s
+}
+
+// Returns a reader drawing on the limiter of the given transfer. If
+// 'in' is already limited by the manager, the wrap policy applies.
+func (m *Manager) Reader(key StreamKey, in io.Reader) *reader {
+	return m.wrapLimiter(key, in, false).Reader(in)
+}
+
+// Returns a writer drawing on the limiter of the given transfer. If
+// 'out' is already limited by the manager, the wrap policy applies.
+func (m *Manager) Writer(key StreamKey, out io.Writer) *writer {
+	return m.wrapLimiter(key, out, true).Writer(out)
+}
+
+// Sets the journal to record the transfers in when they are forgotten
+// or closed. A nil journal turns recording off.
+func (m *Manager) SetJournal(j Journal) {
+	m.mu.Lock()
+	m.journal = j
+	m.mu.Unlock()
+}
+
+// Drops the state of a finished transfer. Using the key again starts
+// a new transfer.
+func (m *Manager) Forget(key StreamKey) {
+	m.mu.Lock()
+	s, ok := m.streams[key]
+	delete(m.streams, key)
+	j := m.journal
+	m.mu.Unlock()
+	if ok && j != nil {
+		s.record(j, s.report(key, nil))
+	}
+}
+
+// Closes all transfers and returns their final reports ordered by key.
+// Transfers are given until 'ctx' is done to drain, that is to have
+// nobody waiting on their limiters and their last slices over. Then
+// the readers and writers of all transfers fail with ErrClosed, and
+// the streams passed to Reader and Writer are closed if they are
+// io.Closers, which also cuts off transfers blocked on them. The
+// manager is left empty.
+func (m *Manager) CloseAll(ctx context.Context) []StreamReport {
+	m.mu.Lock()
+	streams := m.streams
+	m.streams = make(map[StreamKey]*stream)
+	j := m.journal
+	m.mu.Unlock()
+
+	keys := make([]StreamKey, 0, len(streams))
+	for key := range streams {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	for !drained(streams) {
+		timer := time.NewTimer(drainPoll)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	reports := make([]StreamReport, 0, len(keys))
+	for _, key := range keys {
+		s := streams[key]
+		var err error
+		if !s.limiter.close() {
+			err = ctx.Err()
+		}
+		for _, c := range s.closers {
+			c.Close()
+		}
+		r := s.report(key, err)
+		if j != nil {
+			s.record(j, r)
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// Returns the transfer's report as of now.
+func (s *stream) report(key StreamKey, err error) StreamReport {
+	r := StreamReport{Key: key, Duration: time.Since(s.created), Err: err}
+	r.Bytes = s.limiter.Stats().Bytes
+	if r.Duration > 0 {
+		r.Rate = Rate(float64(r.Bytes) / r.Duration.Seconds())
+	}
+	return r
+}
+
+// Writes the transfer's report to the journal.
+func (s *stream) record(j Journal, r StreamReport) {
+	l := s.limiter
+	l.mu.Lock()
+	rec := Record{
+		Label:    string(r.Key),
+		Start:    s.created,
+		Duration: r.Duration,
+		Bytes:    r.Bytes,
+		Rate:     l.rate,
+		Achieved: r.Rate,
+		Quota:    l.quotaUsed,
+	}
+	l.mu.Unlock()
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+	}
+	if err := j.Record(rec); err != nil {
+		logf("journal record failed: %v", err)
+	}
+}
+
+// Tells whether all the transfers have drained.
+func drained(streams map[StreamKey]*stream) bool {
+	for _, s := range streams {
+		s.limiter.mu.Lock()
+		idle := s.limiter.idle(time.Now())
+		s.limiter.mu.Unlock()
+		if !idle {
+			return false
+		}
+	}
+	return true
+}
+
+// Makes further reservations fail with ErrClosed and tells whether the
+// limiter was idle.
+func (l *Limiter) close() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.err = ErrClosed
+	return l.idle(time.Now())
+}
+
+// Tells whether nobody is waiting on the limiter and its last slice
+// is over. Must be called with the lock held.
+func (l *Limiter) idle(now time.Time) bool {
+	if l.waiters > 0 {
+		return false
+	}
+	return l.perSlice == 0 || l.start.IsZero() || !now.Before(l.start.Add(l.slice))
+}
@@ -0,0 +1,157 @@
+// Measures how accurately the package's limiters keep their rates on
+// the current host, so that projects depending on it can check that
+// in their own CI.
+package measure
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gaswelder/iorate"
+)
+
+// Size of the writes the workers make.
+const chunkSize = 4 * 1024
+
+// Config lists the cases to measure. Every combination of the values
+// is a case. Empty lists get a single default value.
+type Config struct {
+	// Rates to set on the limiter, 1 MBps by default
+	Rates []iorate.Rate
+	// Values for Limiter.SetMaxGap, zero for the default slice length
+	Gaps []time.Duration
+	// Numbers of goroutines writing through the limiter, 1 by default
+	Concurrency []int
+	// How long each case runs, 1 second by default
+	Duration time.Duration
+}
+
+// Result is the outcome of one case.
+type Result struct {
+	Rate        iorate.Rate   `json:"rate"`
+	Gap         time.Duration `json:"gap"`
+	Concurrency int           `json:"concurrency"`
+	// Bytes transferred
+	Bytes int64 `json:"bytes"`
+	// Time from the start to the last write reaching the sink
+	Elapsed time.Duration `json:"elapsed"`
+	// Rate achieved up to the last write. The last write itself is
+	// left out, since after it the sink is idle and a short tail
+	// would make the rate look lower than it was.
+	Achieved iorate.Rate `json:"achieved"`
+	// Relative error of the achieved rate, negative if it's lower
+	Error float64 `json:"error"`
+	// Number of writes that reached the sink
+	Writes int64 `json:"writes"`
+	// Time all the writes took beyond the ideal transfer time of
+	// the bytes at the rate
+	Overhead time.Duration `json:"overhead"`
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// Returns the largest absolute relative error among the results.
+func (r Report) MaxError() float64 {
+	max := 0.0
+	for _, res := range r.Results {
+		max = math.Max(max, math.Abs(res.Error))
+	}
+	return max
+}
+
+// Measures all the cases of the configuration one after another.
+func Run(cfg Config) Report {
+	if len(cfg.Rates) == 0 {
+		cfg.Rates = []iorate.Rate{1 * iorate.MBps}
+	}
+	if len(cfg.Gaps) == 0 {
+		cfg.Gaps = []time.Duration{0}
+	}
+	if len(cfg.Concurrency) == 0 {
+		cfg.Concurrency = []int{1}
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = time.Second
+	}
+
+	var r Report
+	for _, rate := range cfg.Rates {
+		for _, gap := range cfg.Gaps {
+			for _, n := range cfg.Concurrency {
+				r.Results = append(r.Results, measure(rate, gap, n, cfg.Duration))
+			}
+		}
+	}
+	return r
+}
+
+func measure(rate iorate.Rate, gap time.Duration, workers int, d time.Duration) Result {
+	res := Result{Rate: rate, Gap: gap, Concurrency: workers}
+	l := iorate.NewLimiter(rate)
+	l.SetMaxGap(gap)
+
+	s := new(sink)
+	total := int64(float64(rate) * d.Seconds())
+	share := total / int64(workers)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := l.Writer(s)
+			buf := make([]byte, chunkSize)
+			for left := share; left > 0; {
+				b := buf
+				if int64(len(b)) > left {
+					b = b[:left]
+				}
+				n, err := w.Write(b)
+				if err != nil {
+					return
+				}
+				left -= int64(n)
+			}
+		}()
+	}
+	wg.Wait()
+	wall := time.Since(start)
+
+	res.Bytes = s.bytes
+	res.Writes = s.writes
+	res.Elapsed = s.last.Sub(start)
+	if d := s.prev.Sub(start); d > 0 {
+		res.Achieved = iorate.Rate(float64(s.prevBytes) / d.Seconds())
+		res.Error = float64(res.Achieved-rate) / float64(rate)
+	}
+	ideal := time.Duration(float64(s.bytes) / float64(rate) * float64(time.Second))
+	res.Overhead = wall - ideal
+	return res
+}
+
+// A writer that records what reaches it.
+type sink struct {
+	mu     sync.Mutex
+	bytes  int64
+	writes int64
+	// Times of the last two writes and the bytes up to the one
+	// before last
+	prev, last time.Time
+	prevBytes  int64
+}
+
+func (s *sink) Write(b []byte) (int, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prev, s.prevBytes = s.last, s.bytes
+	s.last = now
+	s.bytes += int64(len(b))
+	s.writes++
+	return len(b), nil
+}
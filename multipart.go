@@ -0,0 +1,29 @@
+package iorate
+
+import (
+	"io"
+	"mime/multipart"
+)
+
+// A multipart writer that limits file parts only.
+type multipartWriter struct {
+	*multipart.Writer
+	limiter *Limiter
+}
+
+// Returns a multipart.Writer writing to 'out' whose file parts are
+// limited to 'maxSpeed' bytes per second altogether. Boundaries, part
+// headers and plain form fields are written without delay.
+func NewMultipartWriter(out io.Writer, maxSpeed Rate) *multipartWriter {
+	return &multipartWriter{multipart.NewWriter(out), NewLimiter(maxSpeed)}
+}
+
+// Works like multipart.Writer.CreateFormFile, but writes to the
+// returned part are limited.
+func (t *multipartWriter) CreateFormFile(fieldname, filename string) (io.Writer, error) {
+	part, err := t.Writer.CreateFormFile(fieldname, filename)
+	if err != nil {
+		return nil, err
+	}
+	return t.limiter.Writer(part), nil
+}
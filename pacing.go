@@ -0,0 +1,168 @@
+package iorate
+
+/*
+	Besides the fixed slices, a limiter can pace its budget in three
+	other ways, all of which keep a single cursor: the time up to which
+	the budget has been spent, with 'n' bytes moving it by n/L.
+
+	A leaky bucket lets an operation go when the cursor is reached, so
+	the operations are spread evenly and never burst. A token bucket
+	lets it go up to a slice ahead of the cursor, which is a bucket
+	holding a slice's worth of budget: after a pause, that much passes
+	at once. Both forget the time a limiter stays idle, since the
+	cursor can't fall behind the current time. An absolute deadline
+	schedule doesn't: byte k of the transfer is due k/L after its
+	start, and a transfer that has fallen behind, because the network
+	stalled for example, catches up at full speed. How far behind it
+	may fall is capped, by default at a slice, so that a limiter
+	coming back from a long idle spell doesn't send everything it
+	missed at once.
+
+	Operations under these strategies take up to a slice's budget at a
+	time, like under fixed slices, but SetLatencySLO doesn't apply to
+	them.
+*/
+
+import "time"
+
+// Strategy is an algorithm a limiter spreads its budget over time with.
+type Strategy int
+
+const (
+	// Budget in fixed time slices, the default. Bursts are at
+	// most a slice long and the wakeups are few.
+	FixedSlice Strategy = iota
+	// A bucket of a slice's worth of budget, refilled at the rate.
+	// Operations after a pause don't wait.
+	TokenBucket
+	// Operations evenly spaced at the rate, without bursts.
+	LeakyBucket
+	// Operations due at fixed times from the start of the transfer,
+	// catching up after stalls.
+	Deadline
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case FixedSlice:
+		return "fixed slice"
+	case TokenBucket:
+		return "token bucket"
+	case LeakyBucket:
+		return "leaky bucket"
+	case Deadline:
+		return "deadline"
+	}
+	return "unknown"
+}
+
+// Sets the pacing strategy. Changing it starts the pacing afresh.
+func (l *Limiter) SetStrategy(s Strategy) {
+	l.mu.Lock()
+	l.strategy = s
+	l.cursor = time.Time{}
+	l.start, l.used = time.Time{}, 0
+	l.mu.Unlock()
+}
+
+// Sets how far the Deadline strategy's schedule may fall behind the
+// current time, which is the most it catches up at full speed. Zero
+// or less means a slice. Horizons longer than a slice let through
+// bursts that SetCertify reports as violations.
+func (l *Limiter) SetCatchUp(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	l.mu.Lock()
+	l.catchUp = d
+	l.mu.Unlock()
+}
+
+// Returns the time it takes to pass 'n' bytes at the limiter's rate.
+// Must be called with the lock held.
+func (l *Limiter) duration(n int) time.Duration {
+	return time.Duration(int64(n) * int64(time.Second) / int64(l.rate))
+}
+
+// Returns the time 'n' bytes may go with the cursor at 'cursor', and
+// the cursor after them. Must be called with the lock held.
+func (l *Limiter) pace(cursor time.Time, n int, now time.Time) (time.Time, time.Time) {
+	d := l.duration(n)
+	switch l.strategy {
+	case TokenBucket:
+		if cursor.Before(now) {
+			cursor = now
+		}
+		cursor = cursor.Add(d)
+		start := cursor.Add(-l.slice)
+		if start.Before(now) {
+			start = now
+		}
+		return start, cursor
+	case LeakyBucket:
+		if cursor.Before(now) {
+			cursor = now
+		}
+		return cursor, cursor.Add(d)
+	}
+	if cursor.IsZero() {
+		cursor = now
+	}
+	horizon := l.catchUp
+	if horizon <= 0 {
+		horizon = l.slice
+	}
+	if cursor.Before(now.Add(-horizon)) {
+		cursor = now.Add(-horizon)
+	}
+	return cursor, cursor.Add(d)
+}
+
+// Reserves up to 'n' bytes under a strategy other than fixed slices.
+// Must be called with the lock held.
+func (l *Limiter) reservePaced(n int, now time.Time) (reservation, error) {
+	if n > l.perSlice {
+		n = l.perSlice
+	}
+	start, _ := l.pace(l.cursor, n, now)
+	delay := start.Sub(now)
+	waiting := delay > 0
+	if err := l.checkLoad(delay); err != nil {
+		return reservation{}, err
+	}
+	r, err := l.charge(reservation{n: n, start: start, wake: start, waiting: waiting}, now)
+	if err != nil {
+		return r, err
+	}
+	if waiting {
+		l.waiters++
+	}
+	_, l.cursor = l.pace(l.cursor, r.n, now)
+	l.meter.add(r.n, now)
+	return r, nil
+}
+
+// Reserves a vector under a strategy other than fixed slices. Must be
+// called with the lock held.
+func (l *Limiter) reserveVectorPaced(sizes []int, total int, now time.Time) (Schedule, error) {
+	schedule := make(Schedule, len(sizes))
+	cursor := l.cursor
+	for i, n := range sizes {
+		schedule[i], cursor = l.pace(cursor, n, now)
+		if schedule[i].Before(now) {
+			schedule[i] = now
+		}
+	}
+	if len(schedule) > 0 {
+		delay := schedule[len(schedule)-1].Sub(now)
+		if l.maxDelay > 0 && delay > l.maxDelay {
+			return nil, &OverloadError{l.waiters, delay}
+		}
+	}
+	if err := l.chargeAll(total, now); err != nil {
+		return nil, err
+	}
+	l.cursor = cursor
+	l.meter.add(total, now)
+	return schedule, nil
+}
@@ -0,0 +1,29 @@
+package iorate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeadlineCatchUpIsCapped(t *testing.T) {
+	l := NewLimiter(10 * KBps)
+	l.SetStrategy(Deadline)
+	now := time.Now()
+	idle := now.Add(-3 * time.Second)
+
+	l.mu.Lock()
+	at, _ := l.pace(idle, 100, now)
+	slice := l.slice
+	l.mu.Unlock()
+	if want := now.Add(-slice); at.Before(want) {
+		t.Errorf("due %v before now, want at most a slice (%v)", now.Sub(at), slice)
+	}
+
+	l.SetCatchUp(time.Second)
+	l.mu.Lock()
+	at, _ = l.pace(idle, 100, now)
+	l.mu.Unlock()
+	if want := now.Add(-time.Second); !at.Equal(want) {
+		t.Errorf("due %v before now, want 1s", now.Sub(at))
+	}
+}
@@ -0,0 +1,54 @@
+package iorate
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// A packet connection with limited rates.
+type packetConn struct {
+	net.PacketConn
+	in, out  *Limiter
+	overhead int64
+}
+
+// Returns a packet connection whose reads and writes are limited to
+// 'readSpeed' and 'writeSpeed' bytes per second. Datagrams are never
+// split, so a datagram larger than a slice's budget waits for as many
+// slices as it takes.
+func NewPacketConn(pc net.PacketConn, readSpeed, writeSpeed Rate) *packetConn {
+	t := new(packetConn)
+	t.PacketConn = pc
+	t.in = NewLimiter(readSpeed)
+	t.out = NewLimiter(writeSpeed)
+	return t
+}
+
+// Sets the number of bytes charged for every datagram on top of its
+// payload, for example 28 for the IPv4 and UDP headers, so that the
+// rate for small datagrams matches that of a real link.
+func (t *packetConn) SetOverhead(n int) {
+	atomic.StoreInt64(&t.overhead, int64(n))
+}
+
+// Implements the net.PacketConn.ReadFrom function. The datagram is
+// charged after it has been received, since its size isn't known
+// before that.
+func (t *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := t.PacketConn.ReadFrom(b)
+	if err != nil {
+		return n, addr, err
+	}
+	if _, err := t.in.take(n + int(atomic.LoadInt64(&t.overhead))); err != nil {
+		return 0, addr, err
+	}
+	return n, addr, nil
+}
+
+// Implements the net.PacketConn.WriteTo function.
+func (t *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if _, err := t.out.take(len(b) + int(atomic.LoadInt64(&t.overhead))); err != nil {
+		return 0, err
+	}
+	return t.PacketConn.WriteTo(b, addr)
+}
@@ -0,0 +1,26 @@
+package iorate
+
+import "io"
+
+// The writing half of a limited pipe.
+type pipeWriter struct {
+	*writer
+	pw *io.PipeWriter
+}
+
+// Returns a synchronous in-memory pipe, like io.Pipe, whose writes are
+// limited to 'maxSpeed' bytes per second.
+func Pipe(maxSpeed Rate) (*io.PipeReader, *pipeWriter) {
+	pr, pw := io.Pipe()
+	return pr, &pipeWriter{NewWriter(pw, maxSpeed), pw}
+}
+
+// Closes the writer, see io.PipeWriter.Close.
+func (t *pipeWriter) Close() error {
+	return t.pw.Close()
+}
+
+// Closes the writer with an error, see io.PipeWriter.CloseWithError.
+func (t *pipeWriter) CloseWithError(err error) error {
+	return t.pw.CloseWithError(err)
+}
@@ -0,0 +1,122 @@
+package iorate
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// A reader returning its data a few bytes at a time.
+type trickle struct {
+	data  []byte
+	chunk int
+	reads int
+}
+
+func (t *trickle) Read(b []byte) (int, error) {
+	if len(t.data) == 0 {
+		return 0, io.EOF
+	}
+	t.reads++
+	n := t.chunk
+	if n > len(b) {
+		n = len(b)
+	}
+	if n > len(t.data) {
+		n = len(t.data)
+	}
+	copy(b, t.data[:n])
+	t.data = t.data[n:]
+	return n, nil
+}
+
+func TestReadReturnsSingleRead(t *testing.T) {
+	src := &trickle{data: make([]byte, 100), chunk: 7}
+	r := NewReader(src, 0)
+	n, err := r.Read(make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 7 || src.reads != 1 {
+		t.Fatalf("got %d bytes in %d reads, want 7 in 1", n, src.reads)
+	}
+}
+
+// Both ends of a synchronous pipe limited by the same limiter must not
+// stall each other in a request-response exchange, where the writer
+// waits for each message to be taken before writing the next one.
+func TestSharedLimiterPipe(t *testing.T) {
+	l := NewLimiter(100 * KBps)
+	l.SetInitialBudget(StartFull)
+	pr, pw := io.Pipe()
+	w := l.Writer(pw)
+	r := l.Reader(pr)
+
+	const rounds = 10
+	msg := bytes.Repeat([]byte("x"), 100)
+	ack := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < rounds; i++ {
+			if _, err := w.Write(msg); err != nil {
+				done <- err
+				return
+			}
+			<-ack
+		}
+		done <- pw.Close()
+	}()
+
+	read := make(chan error)
+	go func() {
+		buf := make([]byte, 4096)
+		got := 0
+		for {
+			n, err := r.Read(buf)
+			got += n
+			if n > 0 && got%len(msg) == 0 {
+				ack <- struct{}{}
+			}
+			if err != nil {
+				if err == io.EOF && got == rounds*len(msg) {
+					err = nil
+				}
+				read <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the exchange stalled")
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShortReadsKeepTheRate(t *testing.T) {
+	const size = 20 * 1024
+	src := &trickle{data: make([]byte, size), chunk: 1000}
+	r := NewReader(src, 100*KBps)
+	buf := make([]byte, 32*1024)
+	start := time.Now()
+	total := 0
+	for total < size {
+		n, err := r.Read(buf)
+		total += n
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 0.2s at the rate plus the first slice's wait.
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("%d bytes in 1000-byte reads took %v at 100 KBps", size, d)
+	}
+}
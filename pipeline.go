@@ -0,0 +1,189 @@
+package iorate
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// A transfer assembled from stages, see Pipeline.
+type pipeline struct {
+	src io.Reader
+	dst io.Writer
+	// The limiter of the first stage and of the last one,
+	// which is a descendant of the first
+	root, limiter *Limiter
+	tees          []io.Writer
+	meters        []*Counter
+	// Bytes delivered so far
+	delivered Counter
+
+	progress      func(PipelineReport)
+	progressEvery time.Duration
+}
+
+// PipelineReport describes the progress or the result of a pipeline.
+type PipelineReport struct {
+	// Bytes delivered to the sink
+	Bytes int64
+	// Time since the pipeline was started and the average rate
+	Duration time.Duration
+	Rate     Rate
+}
+
+var errIncomplete = errors.New("iorate: pipeline needs a source and a sink")
+
+// Returns a builder of a transfer from a source to a sink, so that
+// instead of wrapping the streams and calling io.Copy, one can write
+//
+//	report, err := iorate.Pipeline().From(r).Limit(1 * iorate.MBps).Tee(hasher).To(w).Run(ctx)
+//
+// The order of the stages doesn't matter except for From and To, the
+// data passes through all of them.
+func Pipeline() *pipeline {
+	p := new(pipeline)
+	p.root = NewLimiter(0)
+	p.limiter = p.root
+	return p
+}
+
+// Sets the source.
+func (p *pipeline) From(r io.Reader) *pipeline {
+	p.src = r
+	return p
+}
+
+// Sets the sink.
+func (p *pipeline) To(w io.Writer) *pipeline {
+	p.dst = w
+	return p
+}
+
+// Limits the transfer to 'maxSpeed' bytes per second. With several
+// limits, the lowest one wins.
+func (p *pipeline) Limit(maxSpeed Rate) *pipeline {
+	p.limiter = p.limiter.Child("", maxSpeed)
+	return p
+}
+
+// Makes the transfer also draw on the shared limiter 'l', in place of
+// the one given to an earlier Share. The transfer shows among the
+// children in the shared limiter's Stats until Run returns.
+func (p *pipeline) Share(l *Limiter) *pipeline {
+	p.root.detach()
+	p.root.parent = l
+	if l != nil {
+		l.mu.Lock()
+		l.children = append(l.children, p.root)
+		l.mu.Unlock()
+	}
+	return p
+}
+
+// Passes a copy of the data to 'w', a hash for example. The copy has
+// the bytes the sink has taken. An error from 'w' fails the transfer.
+func (p *pipeline) Tee(w io.Writer) *pipeline {
+	p.tees = append(p.tees, w)
+	return p
+}
+
+// Adds the bytes delivered to the sink to 'c'.
+func (p *pipeline) Meter(c *Counter) *pipeline {
+	p.meters = append(p.meters, c)
+	return p
+}
+
+// Calls 'f' with the progress every 'every' while the transfer runs.
+func (p *pipeline) Progress(every time.Duration, f func(PipelineReport)) *pipeline {
+	p.progressEvery = every
+	p.progress = f
+	return p
+}
+
+// Runs the transfer until the source ends, a stage fails or 'ctx' is
+// done, and returns the final report. When 'ctx' is done, the source
+// is closed if it's an io.Closer, so that a transfer blocked on it
+// ends too, and the error is the context's. Bytes are counted as with
+// Relay, so the report, the meters and the limiters agree on the bytes
+// the sink has taken, even when a tee fails.
+func (p *pipeline) Run(ctx context.Context) (PipelineReport, error) {
+	if p.src == nil || p.dst == nil {
+		return PipelineReport{}, errIncomplete
+	}
+	defer p.root.detach()
+	var dst io.Writer = p.dst
+	for _, c := range append(p.meters, &p.delivered) {
+		dst = Meter(c).WrapWriter(dst)
+	}
+	if len(p.tees) > 0 {
+		dst = &teeWriter{dst, p.tees}
+	}
+
+	start := time.Now()
+	report := func() PipelineReport {
+		r := PipelineReport{Bytes: p.delivered.Bytes(), Duration: time.Since(start)}
+		if r.Duration > 0 {
+			r.Rate = Rate(float64(r.Bytes) / r.Duration.Seconds())
+		}
+		return r
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go p.supervise(ctx, done, report)
+
+	_, err := Relay(dst, p.src, p.limiter)
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return report(), err
+}
+
+// Reports the progress and stops the transfer when 'ctx' is done.
+func (p *pipeline) supervise(ctx context.Context, done chan struct{}, report func() PipelineReport) {
+	var tick <-chan time.Time
+	if p.progress != nil && p.progressEvery > 0 {
+		t := time.NewTicker(p.progressEvery)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick:
+			p.progress(report())
+		case <-ctx.Done():
+			p.limiter.mu.Lock()
+			p.limiter.err = ctx.Err()
+			p.limiter.mu.Unlock()
+			if c, ok := p.src.(io.Closer); ok {
+				c.Close()
+			}
+			return
+		}
+	}
+}
+
+// Writes to the sink and then what the sink has taken to the tees,
+// returning the sink's count so that the limiters charge those bytes.
+type teeWriter struct {
+	dst  io.Writer
+	tees []io.Writer
+}
+
+func (t *teeWriter) Write(b []byte) (int, error) {
+	n, err := t.dst.Write(b)
+	for _, w := range t.tees {
+		if err != nil {
+			break
+		}
+		var m int
+		m, err = w.Write(b[:n])
+		if m < n && err == nil {
+			err = io.ErrShortWrite
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,49 @@
+package iorate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestPipelineShareRegistersChild(t *testing.T) {
+	first, shared := NewLimiter(0), NewLimiter(0)
+	var dst bytes.Buffer
+	p := Pipeline().From(bytes.NewReader(make([]byte, 1000))).To(&dst).Share(first).Share(shared)
+	if n := len(first.Stats().Children); n != 0 {
+		t.Errorf("replaced limiter has %d children, want 0", n)
+	}
+	if n := len(shared.Stats().Children); n != 1 {
+		t.Errorf("shared limiter has %d children, want 1", n)
+	}
+
+	if _, err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s := shared.Stats()
+	if len(s.Children) != 0 {
+		t.Errorf("shared limiter has %d children after the run, want 0", len(s.Children))
+	}
+	if s.Bytes != 1000 {
+		t.Errorf("shared limiter counted %d bytes, want 1000", s.Bytes)
+	}
+}
+
+func TestPipelineTeeFailure(t *testing.T) {
+	fail := errors.New("tee failed")
+	shared := NewLimiter(0)
+	var dst bytes.Buffer
+	var c Counter
+	tee := Fault(1000, fail).WrapWriter(io.Discard)
+	report, err := Pipeline().From(bytes.NewReader(make([]byte, 100000))).To(&dst).Tee(tee).Meter(&c).Share(shared).Run(context.Background())
+	if err != fail {
+		t.Fatalf("got %v, want %v", err, fail)
+	}
+	sink := int64(dst.Len())
+	if report.Bytes != sink || c.Bytes() != sink || shared.Stats().Bytes != sink {
+		t.Errorf("sink got %d bytes, report has %d, meter %d, limiter %d",
+			sink, report.Bytes, c.Bytes(), shared.Stats().Bytes)
+	}
+}
@@ -0,0 +1,188 @@
+package iorate
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is returned by reads and writes when the limiter's
+// quota for the current period is used up.
+var ErrQuotaExceeded = errors.New("iorate: quota exceeded")
+
+// Period is the length of a quota's accounting period.
+type Period int
+
+const (
+	// Daily periods start at midnight.
+	Daily Period = iota
+	// Monthly periods start at midnight on the 1st.
+	Monthly
+)
+
+// QuotaState is what a QuotaStore keeps between runs.
+type QuotaState struct {
+	// Start of the period the usage belongs to
+	Period time.Time
+	// Bytes used in the period
+	Used int64
+}
+
+// QuotaStore persists a quota's usage, so that restarting the program
+// doesn't give it a fresh allowance.
+type QuotaStore interface {
+	Load() (QuotaState, error)
+	Save(QuotaState) error
+}
+
+// Quota is an allowance of bytes per calendar period, like the ones of
+// metered mobile and satellite plans. It is attached to limiters with
+// SetQuota.
+type Quota struct {
+	mu     sync.Mutex
+	limit  int64
+	period Period
+	loc    *time.Location
+	store  QuotaStore
+
+	// Current period and the bytes used in it
+	start time.Time
+	used  int64
+}
+
+// Returns a quota of 'limit' bytes per period, with the periods
+// starting at midnight in the given location, or in local time if
+// 'loc' is nil.
+func NewQuota(limit int64, period Period, loc *time.Location) *Quota {
+	if loc == nil {
+		loc = time.Local
+	}
+	q := new(Quota)
+	q.limit = limit
+	q.period = period
+	q.loc = loc
+	return q
+}
+
+// Sets the store for the quota's usage and loads the usage from it.
+// A state belonging to a past period is ignored.
+func (q *Quota) SetStore(s QuotaStore) error {
+	state, err := s.Load()
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.store = s
+	q.roll(time.Now())
+	if state.Period.Equal(q.start) {
+		q.used = state.Used
+	}
+	return nil
+}
+
+// Saves the quota's usage to the store. The usage is also saved
+// automatically when a period ends.
+func (q *Quota) Save() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.store == nil {
+		return nil
+	}
+	return q.store.Save(QuotaState{q.start, q.used})
+}
+
+// Returns the number of bytes left in the current period.
+func (q *Quota) Remaining() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(time.Now())
+	if q.used >= q.limit {
+		return 0
+	}
+	return q.limit - q.used
+}
+
+// Returns the time the current period ends and the allowance renews.
+func (q *Quota) Renews() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(time.Now())
+	return q.next(q.start)
+}
+
+// Takes up to 'n' bytes from the allowance and returns how many were
+// taken.
+func (q *Quota) take(n int, now time.Time) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(now)
+	if left := q.limit - q.used; int64(n) > left {
+		if left < 0 {
+			left = 0
+		}
+		n = int(left)
+	}
+	q.used += int64(n)
+	return n
+}
+
+// Gives 'n' bytes back to the allowance.
+func (q *Quota) refund(n int) {
+	q.mu.Lock()
+	q.used -= int64(n)
+	if q.used < 0 {
+		q.used = 0
+	}
+	q.mu.Unlock()
+}
+
+// Returns the period 'now' belongs to and the usage in it.
+func (q *Quota) state(now time.Time) QuotaState {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(now)
+	return QuotaState{q.start, q.used}
+}
+
+// Raises the usage to the one in 's' if 's' is for the current period.
+func (q *Quota) resume(s QuotaState) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(time.Now())
+	if s.Period.Equal(q.start) && s.Used > q.used {
+		q.used = s.Used
+	}
+}
+
+// Moves on to the period 'now' belongs to, if it's not current.
+func (q *Quota) roll(now time.Time) {
+	start := q.periodStart(now)
+	if start.Equal(q.start) {
+		return
+	}
+	if q.store != nil && !q.start.IsZero() {
+		// There's nobody to report the error to, the next Save
+		// will try again anyway.
+		q.store.Save(QuotaState{start, 0})
+	}
+	q.start = start
+	q.used = 0
+}
+
+// Returns the start of the period containing 't'.
+func (q *Quota) periodStart(t time.Time) time.Time {
+	t = t.In(q.loc)
+	if q.period == Monthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, q.loc)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, q.loc)
+}
+
+// Returns the start of the period following the one starting at 'start'.
+func (q *Quota) next(start time.Time) time.Time {
+	if q.period == Monthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
@@ -0,0 +1,281 @@
+package iorate
+
+/*
+	RecommendStrategy doesn't go by a table. Which strategy works best
+	depends on the host about as much as on the strategies: a leaky
+	bucket is only smooth if the sleeps are fine enough, and a deadline
+	schedule only catches up if the wakeups come in time. So the first
+	time it's asked about a rate and a need, it runs a short trial of
+	every strategy at that rate, all at once since they mostly sleep,
+	and scores the trials on what the need is about.
+
+	A trial writes packet-sized chunks to a sink that records when they
+	arrive: a steady run of a few slices' worth of data, a small write
+	after a pause of half a slice, and another run with the sink
+	stalling for a slice at its start. A run that falls far behind is
+	cut short, so that a strategy that can't keep up on the host
+	doesn't make the trial drag on, and it's scored on what it has
+	sent. One trial serves all the needs, so the results are kept per
+	rate.
+*/
+
+import (
+	"sync"
+	"time"
+)
+
+// Smoothness is what a transfer needs from its pacing besides the
+// average rate.
+type Smoothness int
+
+const (
+	// Only the average rate matters, like for bulk downloads.
+	Throughput Smoothness = iota
+	// Bursts are fine, but the first bytes after a pause should
+	// not wait, like for request and response exchanges.
+	Interactive
+	// Bytes should be spread evenly, like for a link that drops
+	// what arrives in bursts.
+	Smooth
+	// Bytes are due at fixed times, like for media playback that
+	// must make up for stalls.
+	Realtime
+)
+
+// Size of the smallest operation worth spacing out, about a packet.
+const smallestChunk = 1500
+
+// Number of slices' worth of data in each run of a trial.
+const trialSlices = 2
+
+// Strategies in the order ties are settled in, after the one built
+// for the needs: the fewer wakeups the better.
+var strategies = []Strategy{FixedSlice, TokenBucket, LeakyBucket, Deadline}
+
+// The strategy built for each of the needs.
+var designedFor = map[Smoothness]Strategy{
+	Throughput:  FixedSlice,
+	Interactive: TokenBucket,
+	Smooth:      LeakyBucket,
+	Realtime:    Deadline,
+}
+
+var (
+	trialsMu sync.Mutex
+	// Results of the trials of the strategies, by rate
+	trials = make(map[Rate][]trialResult)
+)
+
+// Returns the strategy that suits a transfer at 'maxSpeed' bytes per
+// second with the given needs on this host, as found by trying them.
+// The first call for a rate runs a trial of every strategy, which
+// takes up to a dozen time slices, not much over a second with the
+// default slices, and later calls for the rate use the results. The
+// trials are scored on how far below the rate they fall for
+// Throughput, on the
+// delay of a write after a pause for Interactive, on their largest
+// burst for Smooth and on how far behind they end up after a stall
+// of the sink for Realtime. Strategies that fall well below the rate
+// on the host are left out whatever the needs. Between strategies
+// scoring about the same, the one built for the needs wins, and then
+// the one with the fewest wakeups. Without a rate the answer is
+// FixedSlice.
+func RecommendStrategy(maxSpeed Rate, needs Smoothness) Strategy {
+	if maxSpeed <= 0 {
+		return FixedSlice
+	}
+	return choose(trialsAt(maxSpeed), needs)
+}
+
+// Most a strategy may fall further below the rate than the best one
+// and still be considered.
+const maxSlowness = 0.1
+
+// Picks the strategy for the needs from the results of the trials of
+// all strategies.
+func choose(results []trialResult, needs Smoothness) Strategy {
+	fastest := 1.0
+	for _, r := range results {
+		if r.slowness < fastest {
+			fastest = r.slowness
+		}
+	}
+	scores := make(map[Strategy]float64)
+	best := 0.0
+	for i, s := range strategies {
+		if results[i].slowness > fastest+maxSlowness {
+			continue
+		}
+		scores[s] = results[i].score(needs)
+		if len(scores) == 1 || scores[s] < best {
+			best = scores[s]
+		}
+	}
+	for _, s := range append([]Strategy{designedFor[needs]}, strategies...) {
+		if score, ok := scores[s]; ok && score <= best+tieMargin(needs) {
+			return s
+		}
+	}
+	return FixedSlice
+}
+
+// Returns the results of the trials of all strategies at 'rate',
+// running them the first time.
+func trialsAt(rate Rate) []trialResult {
+	trialsMu.Lock()
+	defer trialsMu.Unlock()
+	if results, ok := trials[rate]; ok {
+		return results
+	}
+	results := make([]trialResult, len(strategies))
+	var wg sync.WaitGroup
+	for i, s := range strategies {
+		wg.Add(1)
+		go func(i int, s Strategy) {
+			defer wg.Done()
+			results[i] = trial(s, rate)
+		}(i, s)
+	}
+	wg.Wait()
+	trials[rate] = results
+	return results
+}
+
+// The outcome of a trial of a strategy.
+type trialResult struct {
+	// How far the steady run fell below the limiter's rate, as a
+	// fraction of it
+	slowness float64
+	// Most bytes arriving within a packet's time, or within the
+	// sleep resolution if that's longer, relative to what the rate
+	// allows in that time
+	burst float64
+	// Time a small write took after a pause
+	latency time.Duration
+	// Time the stalled run took beyond what the data it sent takes
+	// at the rate
+	lag time.Duration
+}
+
+// Returns how badly the trial went for the needs, lower is better.
+func (r trialResult) score(needs Smoothness) float64 {
+	switch needs {
+	case Interactive:
+		return r.latency.Seconds()
+	case Smooth:
+		return r.burst
+	case Realtime:
+		return r.lag.Seconds()
+	}
+	return r.slowness
+}
+
+// Returns how far from the best score a strategy may be and still be
+// taken for as good.
+func tieMargin(needs Smoothness) float64 {
+	switch needs {
+	case Interactive, Realtime:
+		return (2 * time.Millisecond).Seconds()
+	case Smooth:
+		return 0.25
+	}
+	return 0.02
+}
+
+// Runs a short transfer at 'rate' under the strategy 's'.
+func trial(s Strategy, rate Rate) trialResult {
+	l := NewLimiter(rate)
+	l.SetStrategy(s)
+	l.mu.Lock()
+	slice, perSlice := l.slice, l.perSlice
+	l.mu.Unlock()
+
+	sink := new(trialSink)
+	w := l.Writer(sink)
+	chunk := make([]byte, smallestChunk)
+	run := int(int64(rate) * int64(trialSlices*slice) / int64(time.Second))
+	// Sends 'n' bytes, giving up after 'limit', and returns the
+	// bytes sent and the time it took.
+	send := func(n int, limit time.Duration) (int, time.Duration) {
+		start := time.Now()
+		sent := 0
+		for sent < n && time.Since(start) < limit {
+			b := chunk
+			if len(b) > n-sent {
+				b = b[:n-sent]
+			}
+			w.Write(b)
+			sent += len(b)
+		}
+		return sent, time.Since(start)
+	}
+	// Time the data of a run takes at the rate plus some slack
+	limit := 2 * (trialSlices + 1) * slice
+
+	var r trialResult
+	sent, d := send(run, limit)
+	if achieved := float64(sent) / d.Seconds(); achieved < float64(rate) {
+		r.slowness = 1 - achieved/float64(rate)
+	}
+	window := time.Duration(smallestChunk * int64(time.Second) / int64(rate))
+	if res := hostClockReport().SleepResolution; window < res {
+		window = res
+	}
+	r.burst = sink.burst(window) / (float64(rate) * window.Seconds())
+
+	time.Sleep(slice / 2)
+	probe := smallestChunk
+	if probe > perSlice {
+		probe = perSlice
+	}
+	_, r.latency = send(probe, limit)
+
+	sink.stallFor(slice)
+	sent, d = send(run, limit)
+	r.lag = d - time.Duration(int64(sent)*int64(time.Second)/int64(rate))
+	return r
+}
+
+// A writer recording when the data reaches it, which can be made to
+// stall on the next write.
+type trialSink struct {
+	arrivals []arrival
+	stall    time.Duration
+}
+
+type arrival struct {
+	at time.Time
+	n  int
+}
+
+func (s *trialSink) Write(b []byte) (int, error) {
+	if s.stall > 0 {
+		time.Sleep(s.stall)
+		s.stall = 0
+	}
+	s.arrivals = append(s.arrivals, arrival{time.Now(), len(b)})
+	return len(b), nil
+}
+
+// Makes the next write wait for 'd' and forgets the arrivals so far.
+func (s *trialSink) stallFor(d time.Duration) {
+	s.stall = d
+	s.arrivals = nil
+}
+
+// Returns the most bytes that have arrived within a time of 'window'.
+func (s *trialSink) burst(window time.Duration) float64 {
+	most, sum := 0, 0
+	j := 0
+	for _, a := range s.arrivals {
+		sum += a.n
+		for a.at.Sub(s.arrivals[j].at) >= window {
+			sum -= s.arrivals[j].n
+			j++
+		}
+		if sum > most {
+			most = sum
+		}
+	}
+	return float64(most)
+}
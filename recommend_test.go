@@ -0,0 +1,70 @@
+package iorate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChooseDesignedOnTie(t *testing.T) {
+	same := trialResult{burst: 1, latency: time.Millisecond}
+	results := []trialResult{same, same, same, same}
+	for needs, want := range designedFor {
+		if got := choose(results, needs); got != want {
+			t.Errorf("needs %d: got %v, want %v", needs, got, want)
+		}
+	}
+}
+
+func TestChooseBestScore(t *testing.T) {
+	results := []trialResult{
+		{burst: 70, latency: 50 * time.Millisecond, lag: 100 * time.Millisecond},
+		{burst: 70, latency: 0, lag: -time.Millisecond},
+		{burst: 2, latency: 0, lag: 100 * time.Millisecond},
+		{burst: 3, latency: 30 * time.Millisecond, lag: 0},
+	}
+	for needs, want := range map[Smoothness]Strategy{
+		Interactive: TokenBucket,
+		Smooth:      LeakyBucket,
+		Realtime:    Deadline,
+	} {
+		if got := choose(results, needs); got != want {
+			t.Errorf("needs %d: got %v, want %v", needs, got, want)
+		}
+	}
+	// Without the deadline's lead, the token bucket catches up
+	// best.
+	results[3].lag = 100 * time.Millisecond
+	if got := choose(results, Realtime); got != TokenBucket {
+		t.Errorf("got %v, want the token bucket", got)
+	}
+}
+
+func TestChooseLeavesOutSlowStrategies(t *testing.T) {
+	results := []trialResult{
+		{burst: 40},
+		{burst: 40},
+		{burst: 3, slowness: 0.9},
+		{burst: 40},
+	}
+	if got := choose(results, Smooth); got == LeakyBucket {
+		t.Errorf("chose the leaky bucket, which fell far below the rate")
+	}
+}
+
+func TestRecommendWithoutRate(t *testing.T) {
+	if got := RecommendStrategy(0, Smooth); got != FixedSlice {
+		t.Errorf("got %v, want fixed slices", got)
+	}
+}
+
+func TestRecommendKeepsTrials(t *testing.T) {
+	first := RecommendStrategy(1*MBps, Smooth)
+	start := time.Now()
+	if got := RecommendStrategy(1*MBps, Smooth); got != first {
+		t.Errorf("got %v, then %v", first, got)
+	}
+	RecommendStrategy(1*MBps, Realtime)
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Errorf("later calls took %v, the trials were run again", d)
+	}
+}
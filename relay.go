@@ -0,0 +1,48 @@
+package iorate
+
+import "io"
+
+// Size of the relay buffer.
+const relayBufferSize = 32 * 1024
+
+// Copies from 'src' to 'dst' like io.Copy, drawing on the limiter's
+// budget once for both sides. Wrapping the source in a limited reader
+// and the destination in a limited writer would charge every byte
+// twice, and the two layers would disagree whenever a write fails
+// halfway.
+//
+// Accounting is byte-exact: the bytes charged to the limiter, its
+// ancestors and its quota, and counted in their Stats, are exactly
+// the returned count of bytes written to 'dst'. Bytes read but not
+// written are given back.
+func Relay(dst io.Writer, src io.Reader, l *Limiter) (written int64, err error) {
+	buf := make([]byte, relayBufferSize)
+	for {
+		r, err := l.reserve(len(buf))
+		if err != nil {
+			return written, err
+		}
+		l.wait(r)
+
+		nr, rerr := src.Read(buf[:r.n])
+		nw := 0
+		var werr error
+		if nr > 0 {
+			nw, werr = dst.Write(buf[:nr])
+			if nw < nr && werr == nil {
+				werr = io.ErrShortWrite
+			}
+		}
+		l.release(r, r.n-nw)
+		written += int64(nw)
+
+		switch {
+		case werr != nil:
+			return written, werr
+		case rerr == io.EOF:
+			return written, nil
+		case rerr != nil:
+			return written, rerr
+		}
+	}
+}
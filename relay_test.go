@@ -0,0 +1,118 @@
+package iorate
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// A writer taking at most 'max' bytes of every write without failing.
+type shortWriter struct {
+	bytes.Buffer
+	max int
+}
+
+func (w *shortWriter) Write(b []byte) (int, error) {
+	if len(b) > w.max {
+		b = b[:w.max]
+	}
+	return w.Buffer.Write(b)
+}
+
+// Makes a child limiter with a quota of 'limit' bytes under an
+// unlimited parent.
+func relayLimiters(limit int64) (parent, child *Limiter, q *Quota) {
+	parent = NewLimiter(0)
+	child = parent.Child("relay", 10*MBps)
+	child.SetInitialBudget(StartFull)
+	q = NewQuota(limit, Daily, time.UTC)
+	child.SetQuota(q)
+	return parent, child, q
+}
+
+// Checks that the limiters and the quota have been charged exactly
+// 'n' bytes.
+func checkCharged(t *testing.T, parent, child *Limiter, q *Quota, limit, n int64) {
+	t.Helper()
+	if got := child.Stats().Bytes; got != n {
+		t.Errorf("child counted %d bytes, want %d", got, n)
+	}
+	if got := parent.Stats().Bytes; got != n {
+		t.Errorf("parent counted %d bytes, want %d", got, n)
+	}
+	if got := q.Remaining(); got != limit-n {
+		t.Errorf("quota has %d left, want %d", got, limit-n)
+	}
+}
+
+func TestRelayThroughChild(t *testing.T) {
+	parent, child, q := relayLimiters(1 << 20)
+	data := bytes.Repeat([]byte("relay"), 20000)
+	var dst bytes.Buffer
+	n, err := Relay(&dst, bytes.NewReader(data), child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(dst.Bytes(), data) {
+		t.Fatalf("relayed %d bytes, want %d", n, len(data))
+	}
+	checkCharged(t, parent, child, q, 1<<20, n)
+}
+
+func TestRelayQuotaExceeded(t *testing.T) {
+	parent, child, q := relayLimiters(50000)
+	var dst bytes.Buffer
+	n, err := Relay(&dst, bytes.NewReader(make([]byte, 100000)), child)
+	if err != ErrQuotaExceeded {
+		t.Fatalf("got %v, want ErrQuotaExceeded", err)
+	}
+	if n != 50000 || int64(dst.Len()) != n {
+		t.Fatalf("relayed %d bytes, sink got %d, want 50000", n, dst.Len())
+	}
+	checkCharged(t, parent, child, q, 50000, n)
+}
+
+func TestRelayShortWrite(t *testing.T) {
+	parent, child, q := relayLimiters(1 << 20)
+	dst := &shortWriter{max: 1000}
+	n, err := Relay(dst, bytes.NewReader(make([]byte, 10000)), child)
+	if err != io.ErrShortWrite {
+		t.Fatalf("got %v, want io.ErrShortWrite", err)
+	}
+	if n != 1000 || int64(dst.Len()) != n {
+		t.Fatalf("relayed %d bytes, sink got %d, want 1000", n, dst.Len())
+	}
+	checkCharged(t, parent, child, q, 1<<20, n)
+}
+
+func TestRelayWriteError(t *testing.T) {
+	parent, child, q := relayLimiters(1 << 20)
+	fail := errors.New("write failed")
+	var dst bytes.Buffer
+	w := Fault(40000, fail).WrapWriter(&dst)
+	n, err := Relay(w, bytes.NewReader(make([]byte, 100000)), child)
+	if err != fail {
+		t.Fatalf("got %v, want %v", err, fail)
+	}
+	if n != 40000 || int64(dst.Len()) != n {
+		t.Fatalf("relayed %d bytes, sink got %d, want 40000", n, dst.Len())
+	}
+	checkCharged(t, parent, child, q, 1<<20, n)
+}
+
+func TestRelayReadError(t *testing.T) {
+	parent, child, q := relayLimiters(1 << 20)
+	fail := errors.New("read failed")
+	src := Fault(40000, fail).WrapReader(bytes.NewReader(make([]byte, 100000)))
+	var dst bytes.Buffer
+	n, err := Relay(&dst, src, child)
+	if err != fail {
+		t.Fatalf("got %v, want %v", err, fail)
+	}
+	if n != 40000 || int64(dst.Len()) != n {
+		t.Fatalf("relayed %d bytes, sink got %d, want 40000", n, dst.Len())
+	}
+	checkCharged(t, parent, child, q, 1<<20, n)
+}
@@ -0,0 +1,42 @@
+package iorate
+
+// RetryPolicy tells a writer how to account for data written again
+// after a failed attempt.
+type RetryPolicy int
+
+const (
+	// ChargeRetries charges retried bytes like any others, so the
+	// limit applies to what goes over the wire.
+	ChargeRetries RetryPolicy = iota
+	// ExemptRetries lets retried bytes through without charging
+	// them, so the limit applies to the payload, each byte of which
+	// is counted once.
+	ExemptRetries
+)
+
+// Sets how WriteRetry accounts for the data. The default is
+// ChargeRetries.
+func (t *writer) SetRetryPolicy(p RetryPolicy) {
+	t.retries = p
+}
+
+// Writes data that has already been written once, for example after
+// the previous attempt failed, accounting for it according to the
+// writer's retry policy.
+func (t *writer) WriteRetry(b []byte) (int, error) {
+	if t.retries == ExemptRetries {
+		return t.out.Write(b)
+	}
+	return t.Write(b)
+}
+
+// Sets the connection's retry policy, see writer.SetRetryPolicy.
+func (t *conn) SetRetryPolicy(p RetryPolicy) {
+	t.w.SetRetryPolicy(p)
+}
+
+// Writes retried data, see writer.WriteRetry.
+func (t *conn) WriteRetry(b []byte) (int, error) {
+	t.writeDelay.wait()
+	return t.w.WriteRetry(b)
+}
@@ -0,0 +1,99 @@
+package iorate
+
+import "time"
+
+// Stats describes the traffic through a limiter.
+type Stats struct {
+	// The name given to Child, empty for a limiter made by NewLimiter
+	Name string
+	// Bytes passed since the limiter was created
+	Bytes int64
+	// Rate achieved over the last second
+	Rate Rate
+	// Stats of the child limiters. Their traffic is included in the
+	// parent's numbers, along with the traffic of the parent's own
+	// readers and writers.
+	Children []Stats
+}
+
+// Returns a limiter with its own rate of 'maxSpeed' bytes per second
+// that also draws on this one, so that the children of a limiter stay
+// within its rate altogether. A rate of zero or less means the child
+// is limited by its ancestors only.
+func (l *Limiter) Child(name string, maxSpeed Rate) *Limiter {
+	c := NewLimiter(maxSpeed)
+	c.name = name
+	c.parent = l
+	l.mu.Lock()
+	l.children = append(l.children, c)
+	l.mu.Unlock()
+	return c
+}
+
+// Removes the limiter from its parent's children, so that it no longer
+// shows in the parent's Stats. Its traffic stays counted in the
+// parent's totals.
+func (l *Limiter) detach() {
+	p := l.parent
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	for i, c := range p.children {
+		if c == l {
+			p.children = append(p.children[:i], p.children[i+1:]...)
+			break
+		}
+	}
+	p.mu.Unlock()
+}
+
+// Returns the traffic statistics of the limiter and its descendants.
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	s := Stats{Name: l.name, Bytes: l.meter.total, Rate: l.meter.rate(time.Now())}
+	children := append([]*Limiter(nil), l.children...)
+	l.mu.Unlock()
+
+	for _, c := range children {
+		s.Children = append(s.Children, c.Stats())
+	}
+	return s
+}
+
+// A byte counter that also estimates the rate over the last second
+// using two one-second buckets.
+type meter struct {
+	total int64
+	// Start of the current bucket
+	second    time.Time
+	cur, prev int64
+}
+
+func (m *meter) add(n int, now time.Time) {
+	m.total += int64(n)
+	m.roll(now)
+	m.cur += int64(n)
+}
+
+func (m *meter) rate(now time.Time) Rate {
+	m.roll(now)
+	// Count the part of the previous second that's still within the
+	// last second.
+	frac := float64(now.Sub(m.second)) / float64(time.Second)
+	return Rate(float64(m.prev)*(1-frac) + float64(m.cur))
+}
+
+// Moves the buckets forward so that the current one contains 'now'.
+func (m *meter) roll(now time.Time) {
+	switch d := now.Sub(m.second); {
+	case d < time.Second:
+		return
+	case d < 2*time.Second:
+		m.second = m.second.Add(time.Second)
+		m.prev, m.cur = m.cur, 0
+	default:
+		m.second = now
+		m.prev, m.cur = 0, 0
+	}
+}
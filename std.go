@@ -0,0 +1,59 @@
+package iorate
+
+import "os"
+
+// A limited reader of a file that still gives access to the file.
+type fileReader struct {
+	*reader
+	f *os.File
+}
+
+// A limited writer to a file that still gives access to the file.
+type fileWriter struct {
+	*writer
+	f *os.File
+}
+
+// Returns the standard input limited to 'maxSpeed' bytes per second.
+func Stdin(maxSpeed Rate) *fileReader {
+	return &fileReader{NewReader(os.Stdin, maxSpeed), os.Stdin}
+}
+
+// Returns the standard output limited to 'maxSpeed' bytes per second.
+func Stdout(maxSpeed Rate) *fileWriter {
+	return &fileWriter{NewWriter(os.Stdout, maxSpeed), os.Stdout}
+}
+
+// Returns the standard error limited to 'maxSpeed' bytes per second.
+func Stderr(maxSpeed Rate) *fileWriter {
+	return &fileWriter{NewWriter(os.Stderr, maxSpeed), os.Stderr}
+}
+
+// Returns the file's descriptor, so that code checking for a terminal
+// with it keeps working. Anything done with the descriptor directly,
+// reading included, is not limited.
+func (t *fileReader) Fd() uintptr {
+	return t.f.Fd()
+}
+
+// Returns the file's name.
+func (t *fileReader) Name() string {
+	return t.f.Name()
+}
+
+// Returns the file's descriptor, so that code checking for a terminal
+// with it keeps working. Anything done with the descriptor directly,
+// writing included, is not limited.
+func (t *fileWriter) Fd() uintptr {
+	return t.f.Fd()
+}
+
+// Returns the file's name.
+func (t *fileWriter) Name() string {
+	return t.f.Name()
+}
+
+// Commits the file's contents to storage, see os.File.Sync.
+func (t *fileWriter) Sync() error {
+	return t.f.Sync()
+}
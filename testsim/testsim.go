@@ -0,0 +1,93 @@
+// Runs application client and server code against each other over a
+// simulated link, for tests like "sync completes within 10 seconds
+// on a 256 Kbps line".
+package testsim
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gaswelder/iorate"
+)
+
+// Profile describes the link between the client and the server.
+// A zero rate means no limit in that direction.
+type Profile struct {
+	// Client to server rate
+	Up iorate.Rate
+	// Server to client rate
+	Down iorate.Rate
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	// Time from the start until both sides returned
+	Duration time.Duration
+	// Bytes sent in each direction
+	Up, Down int64
+	// Achieved rates in each direction
+	UpRate, DownRate iorate.Rate
+}
+
+// Runs 'server' and 'client' concurrently, each given its end of a
+// link with the profile 'p', and waits for both to return. An end is
+// closed when its function returns, so the other side sees EOF. The
+// error is the client's error or, if there is none, the server's.
+func Run(p Profile, server, client func(conn io.ReadWriteCloser) error) (Report, error) {
+	var up, down iorate.Counter
+	upr, upw := iorate.Pipe(p.Up)
+	downr, downw := iorate.Pipe(p.Down)
+
+	clientEnd := &end{downr, iorate.Meter(&up).WrapWriter(upw), upw}
+	serverEnd := &end{upr, iorate.Meter(&down).WrapWriter(downw), downw}
+
+	var clientErr, serverErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	start := time.Now()
+	go func() {
+		defer wg.Done()
+		clientErr = client(clientEnd)
+		clientEnd.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		serverErr = server(serverEnd)
+		serverEnd.Close()
+	}()
+	wg.Wait()
+
+	r := Report{Duration: time.Since(start), Up: up.Bytes(), Down: down.Bytes()}
+	if secs := r.Duration.Seconds(); secs > 0 {
+		r.UpRate = iorate.Rate(float64(r.Up) / secs)
+		r.DownRate = iorate.Rate(float64(r.Down) / secs)
+	}
+	if clientErr != nil {
+		return r, clientErr
+	}
+	return r, serverErr
+}
+
+// One end of the link.
+type end struct {
+	in  *io.PipeReader
+	out io.Writer
+	// The pipe writer under 'out'
+	closer io.Closer
+}
+
+func (t *end) Read(b []byte) (int, error) {
+	return t.in.Read(b)
+}
+
+func (t *end) Write(b []byte) (int, error) {
+	return t.out.Write(b)
+}
+
+// Makes the other side's reads return EOF and its writes fail.
+func (t *end) Close() error {
+	t.in.Close()
+	return t.closer.Close()
+}
@@ -0,0 +1,162 @@
+package iorate
+
+/*
+	A resumption token is a limiter's state written down at the end of
+	a session: the quota used in the current period and the slice the
+	limiter was in. A server gives it to the client, and when the
+	client comes back with it the new session's limiter picks up where
+	the old one stopped, so reconnecting neither resets the quota nor
+	buys a fresh burst.
+
+	Tokens are signed with HMAC-SHA256, so the client can keep one but
+	not alter it. It can still keep an old one, though, and a new
+	session's limiter knows nothing of the tokens that came after it.
+	That's what the sequence number is for: every token carries one
+	more than the token its limiter resumed from, and the server keeps
+	the latest it has handed out to each client and turns down the
+	older ones.
+
+	The encoding is a version byte, six big-endian 64-bit fields - the
+	issue time, the quota period and usage, the slice start and usage,
+	and the sequence number, with times in Unix nanoseconds or zero -
+	and the signature, all in URL-safe base64.
+*/
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// ErrBadToken is returned when a resumption token is malformed or its
+// signature doesn't match.
+var ErrBadToken = errors.New("iorate: bad resumption token")
+
+// Format version of encoded tokens.
+const tokenVersion = 2
+
+// Length of an encoded token before the signature.
+const tokenLength = 1 + 6*8
+
+// ResumeToken is the state of a limiter that a later session can
+// continue from.
+type ResumeToken struct {
+	// Time the token was made
+	Issued time.Time
+	// Usage of the limiter's quota, if it has one
+	Quota QuotaState
+	// Start of the limiter's current slice and the bytes used in it
+	Start time.Time
+	Used  int
+	// Number of the token in the chain of sessions, one more than
+	// the token the limiter resumed from, or the last one it made
+	Seq uint64
+}
+
+// Returns the limiter's current state as a token.
+func (l *Limiter) Token() ResumeToken {
+	var t ResumeToken
+	l.mu.Lock()
+	t.Start, t.Used = l.start, l.used
+	l.tokenSeq++
+	t.Seq = l.tokenSeq
+	q := l.quota
+	l.mu.Unlock()
+	if q != nil {
+		t.Quota = q.state(time.Now())
+	}
+	t.Issued = time.Now()
+	return t
+}
+
+// Continues from the state in 'token'. The quota's usage is restored
+// if the token belongs to the current period, and the slice if it's
+// later than the limiter's own. Neither ever goes back, and a token
+// older than the last one the limiter made or resumed from is
+// ignored. A fresh limiter can't tell an old token from the latest
+// one, so the server has to check Seq against the last token it gave
+// the client, or else an old token wins back the budget used since.
+func (l *Limiter) Resume(token ResumeToken) {
+	l.mu.Lock()
+	if token.Seq < l.tokenSeq {
+		l.mu.Unlock()
+		return
+	}
+	l.tokenSeq = token.Seq
+	switch {
+	case token.Start.After(l.start):
+		l.start, l.used = token.Start, token.Used
+	case token.Start.Equal(l.start) && token.Used > l.used:
+		l.used = token.Used
+	}
+	q := l.quota
+	l.mu.Unlock()
+	if q != nil {
+		q.resume(token.Quota)
+	}
+}
+
+// Returns the token encoded and signed with 'key'.
+func (t ResumeToken) Sign(key []byte) string {
+	b := make([]byte, tokenLength, tokenLength+sha256.Size)
+	b[0] = tokenVersion
+	for i, v := range []int64{
+		unixNano(t.Issued),
+		unixNano(t.Quota.Period),
+		t.Quota.Used,
+		unixNano(t.Start),
+		int64(t.Used),
+		int64(t.Seq),
+	} {
+		binary.BigEndian.PutUint64(b[1+i*8:], uint64(v))
+	}
+	b = append(b, tokenMAC(b, key)...)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Decodes a token made by Sign and checks its signature with 'key'.
+// How old a token may be is up to the caller, who can check Issued.
+func ParseToken(s string, key []byte) (ResumeToken, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) != tokenLength+sha256.Size || b[0] != tokenVersion {
+		return ResumeToken{}, ErrBadToken
+	}
+	if !hmac.Equal(b[tokenLength:], tokenMAC(b[:tokenLength], key)) {
+		return ResumeToken{}, ErrBadToken
+	}
+	field := func(i int) int64 {
+		return int64(binary.BigEndian.Uint64(b[1+i*8:]))
+	}
+	var t ResumeToken
+	t.Issued = fromUnixNano(field(0))
+	t.Quota.Period = fromUnixNano(field(1))
+	t.Quota.Used = field(2)
+	t.Start = fromUnixNano(field(3))
+	t.Used = int(field(4))
+	t.Seq = uint64(field(5))
+	return t, nil
+}
+
+func tokenMAC(b, key []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(b)
+	return m.Sum(nil)
+}
+
+// Converts a time to Unix nanoseconds, with zero for the zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
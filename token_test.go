@@ -0,0 +1,128 @@
+package iorate
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+var tokenKey = []byte("test key")
+
+func TestTokenRoundTrip(t *testing.T) {
+	now := time.Now()
+	want := ResumeToken{
+		Issued: now,
+		Quota:  QuotaState{Period: now.Truncate(time.Hour), Used: 12345},
+		Start:  now.Add(-50 * time.Millisecond),
+		Used:   678,
+		Seq:    9,
+	}
+	got, err := ParseToken(want.Sign(tokenKey), tokenKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Issued.Equal(want.Issued) || !got.Quota.Period.Equal(want.Quota.Period) ||
+		got.Quota.Used != want.Quota.Used || !got.Start.Equal(want.Start) ||
+		got.Used != want.Used || got.Seq != want.Seq {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestTokenZeroTimes(t *testing.T) {
+	got, err := ParseToken(ResumeToken{Seq: 1}.Sign(tokenKey), tokenKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Issued.IsZero() || !got.Start.IsZero() || !got.Quota.Period.IsZero() {
+		t.Errorf("zero times came back as %+v", got)
+	}
+}
+
+func TestTokenTampered(t *testing.T) {
+	s := ResumeToken{Issued: time.Now(), Used: 100, Seq: 3}.Sign(tokenKey)
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range b {
+		c := append([]byte(nil), b...)
+		c[i] ^= 1
+		if _, err := ParseToken(base64.RawURLEncoding.EncodeToString(c), tokenKey); err != ErrBadToken {
+			t.Fatalf("byte %d changed: got %v, want ErrBadToken", i, err)
+		}
+	}
+	if _, err := ParseToken(s[:len(s)-4], tokenKey); err != ErrBadToken {
+		t.Errorf("truncated token: got %v, want ErrBadToken", err)
+	}
+	if _, err := ParseToken("not base64!", tokenKey); err != ErrBadToken {
+		t.Errorf("garbage: got %v, want ErrBadToken", err)
+	}
+}
+
+func TestTokenWrongKey(t *testing.T) {
+	s := ResumeToken{Issued: time.Now()}.Sign(tokenKey)
+	if _, err := ParseToken(s, []byte("other key")); err != ErrBadToken {
+		t.Errorf("got %v, want ErrBadToken", err)
+	}
+}
+
+func TestResumeIgnoresOlderToken(t *testing.T) {
+	q := NewQuota(1000000, Daily, time.UTC)
+	l := NewLimiter(10 * KBps)
+	l.SetQuota(q)
+	old := l.Token()
+	l.Token()
+	if got := l.tokenSeq; got != 2 {
+		t.Fatalf("sequence is %d after two tokens, want 2", got)
+	}
+
+	old.Quota.Used = 5000
+	old.Start, old.Used = time.Now().Add(time.Second), 100
+	l.Resume(old)
+	if got := q.Remaining(); got != 1000000 {
+		t.Errorf("older token restored the quota to %d left", got)
+	}
+	l.mu.Lock()
+	used, seq := l.used, l.tokenSeq
+	l.mu.Unlock()
+	if used != 0 || seq != 2 {
+		t.Errorf("older token restored the slice (%d used) or the sequence (%d)", used, seq)
+	}
+}
+
+func TestResumeRestoresQuota(t *testing.T) {
+	q := NewQuota(1000000, Daily, time.UTC)
+	l := NewLimiter(10 * KBps)
+	l.SetQuota(q)
+	token := l.Token()
+	token.Quota.Used = 5000
+	token.Seq = 1
+
+	m := NewLimiter(10 * KBps)
+	mq := NewQuota(1000000, Daily, time.UTC)
+	m.SetQuota(mq)
+	m.Resume(token)
+	if got := mq.Remaining(); got != 995000 {
+		t.Errorf("quota has %d left, want 995000", got)
+	}
+	if got := m.Token().Seq; got != 2 {
+		t.Errorf("next token has sequence %d, want 2", got)
+	}
+}
+
+func TestResumeIgnoresPastPeriod(t *testing.T) {
+	l := NewLimiter(10 * KBps)
+	q := NewQuota(1000000, Daily, time.UTC)
+	l.SetQuota(q)
+	token := l.Token()
+	token.Quota.Period = token.Quota.Period.AddDate(0, 0, -1)
+	token.Quota.Used = 5000
+
+	m := NewLimiter(10 * KBps)
+	mq := NewQuota(1000000, Daily, time.UTC)
+	m.SetQuota(mq)
+	m.Resume(token)
+	if got := mq.Remaining(); got != 1000000 {
+		t.Errorf("usage from yesterday restored, %d left", got)
+	}
+}
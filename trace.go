@@ -0,0 +1,46 @@
+package iorate
+
+import (
+	"context"
+	"time"
+)
+
+// Trace is a set of hooks called when a limited reader or writer
+// deliberately waits to stay within its rate. It is the counterpart
+// of httptrace.ClientTrace for the time spent shaping rather than
+// on the network. Any of the fields may be nil.
+type Trace struct {
+	// Called after a Read call with the total time it spent waiting.
+	ReadWait func(d time.Duration)
+	// Called after a Write call with the total time it spent waiting.
+	WriteWait func(d time.Duration)
+}
+
+type traceKey struct{}
+
+// Returns a copy of 'ctx' carrying the given trace. Like
+// httptrace.WithClientTrace, this only attaches the hooks; the code
+// that creates the limited reader or writer for a request (a
+// DialContext function, a RoundTripper wrapping the request body)
+// passes ContextTrace(ctx) to the wrapper's SetTrace.
+func WithTrace(ctx context.Context, trace *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// Returns the trace associated with 'ctx' or nil if there is none.
+func ContextTrace(ctx context.Context) *Trace {
+	trace, _ := ctx.Value(traceKey{}).(*Trace)
+	return trace
+}
+
+func (trace *Trace) readWait(d time.Duration) {
+	if trace != nil && trace.ReadWait != nil && d > 0 {
+		trace.ReadWait(d)
+	}
+}
+
+func (trace *Trace) writeWait(d time.Duration) {
+	if trace != nil && trace.WriteWait != nil && d > 0 {
+		trace.WriteWait(d)
+	}
+}
@@ -0,0 +1,85 @@
+package iorate
+
+import (
+	"io"
+	"mime/multipart"
+	"net"
+)
+
+// StreamError is an error of a labelled stream. Readers, writers and
+// connections that have been given a label with SetLabel return their
+// errors, except io.EOF, wrapped in it.
+type StreamError struct {
+	// The stream's label
+	Label string
+	// "read" or "write"
+	Op  string
+	Err error
+}
+
+func (e *StreamError) Error() string {
+	return "iorate: " + e.Label + ": " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// Wraps the error into a StreamError if there is a label.
+func labelError(label, op string, err error) error {
+	if err == nil || err == io.EOF || label == "" {
+		return err
+	}
+	return &StreamError{label, op, err}
+}
+
+// Sets the label that identifies the writer in its errors.
+func (t *writer) SetLabel(label string) {
+	t.label = label
+}
+
+// Sets the label that identifies the reader in its errors.
+func (t *reader) SetLabel(label string) {
+	t.label = label
+}
+
+// Sets the label that identifies the connection in its errors.
+func (t *conn) SetLabel(label string) {
+	t.r.SetLabel(label)
+	t.w.SetLabel(label)
+}
+
+// Returns the underlying writer.
+func (t *writer) Unwrap() io.Writer {
+	return t.out
+}
+
+// Returns the underlying reader.
+func (t *reader) Unwrap() io.Reader {
+	return t.in
+}
+
+// Returns the underlying connection.
+func (t *conn) Unwrap() net.Conn {
+	return t.Conn
+}
+
+// Returns the underlying packet connection.
+func (t *packetConn) Unwrap() net.PacketConn {
+	return t.PacketConn
+}
+
+// Returns the underlying writer.
+func (t *groupWriter) Unwrap() io.Writer {
+	return t.out
+}
+
+// Returns the multipart writer.
+func (t *multipartWriter) Unwrap() *multipart.Writer {
+	return t.Writer
+}
+
+// Returns the writer in front of the encoder.
+func (t *codecWriter) Unwrap() io.Writer {
+	return t.Writer
+}
@@ -0,0 +1,161 @@
+package iorate
+
+import "time"
+
+// Schedule holds the times at which the buffers of a vector
+// reservation may be sent.
+type Schedule []time.Time
+
+// Sleeps until buffer 'i' of the schedule may be sent.
+func (s Schedule) Wait(i int) {
+	sleep(time.Until(s[i]))
+}
+
+// Reserves budget for a sequence of buffers with the given sizes, to
+// be sent in that order, and returns the time each of them may be
+// sent, taking a single lock for the whole sequence. A buffer's time
+// is that of the slice its last byte falls in. The reservation is all
+// or nothing: if the quota can't cover the total or the last buffer
+// would have to wait longer than the maximum delay, nothing is
+// reserved. On a child limiter, the ancestors are reserved from after
+// the child, and if one of them fails the child's part is undone.
+func (l *Limiter) ReserveVector(sizes []int) (Schedule, error) {
+	schedule, undo, err := l.reserveVector(sizes)
+	if err != nil || l.parent == nil {
+		return schedule, err
+	}
+	up, err := l.parent.ReserveVector(sizes)
+	if err != nil {
+		l.undoVector(undo)
+		return nil, err
+	}
+	for i, t := range up {
+		if t.After(schedule[i]) {
+			schedule[i] = t
+		}
+	}
+	return schedule, nil
+}
+
+// A limiter's place in its budget.
+type position struct {
+	start  time.Time
+	used   int
+	cursor time.Time
+}
+
+// What a vector reservation has changed on a limiter.
+type vectorUndo struct {
+	total         int
+	before, after position
+}
+
+// Reserves a vector on this limiter alone.
+func (l *Limiter) reserveVector(sizes []int) (Schedule, vectorUndo, error) {
+	total := 0
+	for _, n := range sizes {
+		total += n
+	}
+
+	l.checkClock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	undo := vectorUndo{total: total, before: l.position()}
+	schedule, err := l.reserveVectorLocked(sizes, total)
+	undo.after = l.position()
+	return schedule, undo, err
+}
+
+// Returns the limiter's place in its budget. Must be called with the
+// lock held.
+func (l *Limiter) position() position {
+	return position{l.start, l.used, l.cursor}
+}
+
+// Takes back a vector reservation: the quota and the traffic count get
+// the bytes back, and the budget does too unless other reservations
+// have been made since.
+func (l *Limiter) undoVector(u vectorUndo) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.quota != nil {
+		l.quota.refund(u.total)
+		l.quotaUsed -= int64(u.total)
+	}
+	l.meter.add(-u.total, now)
+	if l.position() == u.after {
+		l.start, l.used, l.cursor = u.before.start, u.before.used, u.before.cursor
+	}
+}
+
+// Reserves a vector on this limiter alone. Must be called with the
+// lock held.
+func (l *Limiter) reserveVectorLocked(sizes []int, total int) (Schedule, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	now := time.Now()
+	dt := l.slice
+	schedule := make(Schedule, len(sizes))
+	if l.perSlice == 0 {
+		if err := l.chargeAll(total, now); err != nil {
+			return nil, err
+		}
+		l.meter.add(total, now)
+		for i := range schedule {
+			schedule[i] = now
+		}
+		return schedule, nil
+	}
+
+	if l.strategy != FixedSlice {
+		return l.reserveVectorPaced(sizes, total, now)
+	}
+
+	start, used := l.next(now)
+	for i, n := range sizes {
+		for n > 0 {
+			if used >= l.perSlice {
+				start, used = start.Add(dt), 0
+			}
+			g := l.perSlice - used
+			if g > n {
+				g = n
+			}
+			used += g
+			n -= g
+		}
+		schedule[i] = start
+		if start.Before(now) {
+			schedule[i] = now
+		}
+	}
+
+	if len(schedule) > 0 {
+		delay := schedule[len(schedule)-1].Sub(now)
+		if l.maxDelay > 0 && delay > l.maxDelay {
+			return nil, &OverloadError{l.waiters, delay}
+		}
+	}
+	if err := l.chargeAll(total, now); err != nil {
+		return nil, err
+	}
+	l.start, l.used = start, used
+	l.meter.add(total, now)
+	return schedule, nil
+}
+
+// Charges exactly 'n' bytes to the quota, if there is one, or nothing
+// at all.
+func (l *Limiter) chargeAll(n int, now time.Time) error {
+	if l.quota == nil || n == 0 {
+		return nil
+	}
+	if got := l.quota.take(n, now); got < n {
+		l.quota.refund(got)
+		return ErrQuotaExceeded
+	}
+	l.quotaUsed += int64(n)
+	return nil
+}
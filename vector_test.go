@@ -0,0 +1,34 @@
+package iorate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReserveVectorUndoesChildOnParentFailure(t *testing.T) {
+	parent := NewLimiter(0)
+	parent.SetQuota(NewQuota(10, Daily, time.UTC))
+	child := parent.Child("c", 100*KBps)
+	q := NewQuota(1000, Daily, time.UTC)
+	child.SetQuota(q)
+
+	child.mu.Lock()
+	before := child.position()
+	child.mu.Unlock()
+
+	if _, err := child.ReserveVector([]int{100, 100}); err != ErrQuotaExceeded {
+		t.Fatalf("got %v, want ErrQuotaExceeded", err)
+	}
+	if got := q.Remaining(); got != 1000 {
+		t.Errorf("child quota has %d left, want 1000", got)
+	}
+	if got := child.Stats().Bytes; got != 0 {
+		t.Errorf("child counted %d bytes, want 0", got)
+	}
+	child.mu.Lock()
+	after := child.position()
+	child.mu.Unlock()
+	if after != before {
+		t.Errorf("child budget moved from %v to %v", before, after)
+	}
+}
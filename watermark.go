@@ -0,0 +1,51 @@
+package iorate
+
+import "sync"
+
+// Flow control callbacks on the number of bytes waiting in a writer.
+type watermarks struct {
+	mu         sync.Mutex
+	low, high  int
+	onHigh     func()
+	onLow      func()
+	pending    int
+	overloaded bool
+}
+
+// Sets flow control callbacks for the writer. 'onHigh' is called when
+// the number of bytes passed to Write but not yet written out reaches
+// 'high'. After that, 'onLow' is called once the number falls to 'low'
+// or below. A producer would typically pause in 'onHigh' and resume in
+// 'onLow'. The callbacks are called from the writing goroutines and
+// must not write to the writer themselves.
+func (t *writer) SetWatermarks(low, high int, onHigh, onLow func()) {
+	m := new(watermarks)
+	m.low = low
+	m.high = high
+	m.onHigh = onHigh
+	m.onLow = onLow
+	t.marks = m
+}
+
+// Adds 'n', which may be negative, to the number of pending bytes
+// and calls the callback if a watermark has been crossed.
+func (m *watermarks) add(n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.pending += n
+	var f func()
+	switch {
+	case !m.overloaded && m.pending >= m.high:
+		m.overloaded = true
+		f = m.onHigh
+	case m.overloaded && m.pending <= m.low:
+		m.overloaded = false
+		f = m.onLow
+	}
+	m.mu.Unlock()
+	if f != nil {
+		f()
+	}
+}
@@ -0,0 +1,129 @@
+package iorate
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+)
+
+// ErrDoubleWrap is returned by reads and writes of streams that a
+// manager with the WrapError policy was asked to limit a second time.
+var ErrDoubleWrap = errors.New("iorate: stream already limited by this manager")
+
+// WrapPolicy tells a manager what to do with a stream that is already
+// limited by one of its transfers, which would otherwise pay for the
+// same bytes twice.
+type WrapPolicy int
+
+const (
+	// Limit the stream again, for layering that is intended. This
+	// is the default.
+	WrapNest WrapPolicy = iota
+	// Don't limit the stream again and leave it to the existing
+	// limit. The returned wrapper passes the data through as it is.
+	WrapMerge
+	// Make all operations on the returned wrapper fail with
+	// ErrDoubleWrap.
+	WrapError
+)
+
+// Sets the policy for streams already limited by the manager. Streams
+// are recognized by their wrappers: the package's limited readers,
+// writers and connections, and anything in between that has an Unwrap
+// method returning the wrapped stream.
+func (m *Manager) SetWrapPolicy(p WrapPolicy) {
+	m.mu.Lock()
+	m.policy = p
+	m.mu.Unlock()
+}
+
+// Returns the limiter for a new wrapper of 'x' on the given transfer,
+// taking the wrap policy into account.
+func (m *Manager) wrapLimiter(key StreamKey, x interface{}, write bool) *Limiter {
+	l := m.stream(key, x).limiter
+	m.mu.Lock()
+	policy := m.policy
+	m.mu.Unlock()
+	if policy == WrapNest {
+		return l
+	}
+	for _, own := range limitersOf(x, write) {
+		if !m.owns(own) {
+			continue
+		}
+		pass := NewLimiter(0)
+		if policy == WrapError {
+			pass.err = ErrDoubleWrap
+		}
+		return pass
+	}
+	return l
+}
+
+// Tells whether the limiter or one of its ancestors belongs to one of
+// the manager's transfers.
+func (m *Manager) owns(l *Limiter) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for ; l != nil; l = l.parent {
+		for _, s := range m.streams {
+			if s.limiter == l {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Implemented by the package's limited readers and writers.
+type readLimited interface {
+	readLimiter() *Limiter
+}
+
+type writeLimited interface {
+	writeLimiter() *Limiter
+}
+
+func (t *reader) readLimiter() *Limiter {
+	return t.limiter
+}
+
+func (t *writer) writeLimiter() *Limiter {
+	return t.limiter
+}
+
+func (t *conn) readLimiter() *Limiter {
+	return t.r.limiter
+}
+
+func (t *conn) writeLimiter() *Limiter {
+	return t.w.limiter
+}
+
+// Returns the limiters of the reading or writing side of 'x' and of
+// the streams it wraps.
+func limitersOf(x interface{}, write bool) []*Limiter {
+	var found []*Limiter
+	for x != nil {
+		if v, ok := x.(readLimited); ok && !write {
+			found = append(found, v.readLimiter())
+		}
+		if v, ok := x.(writeLimited); ok && write {
+			found = append(found, v.writeLimiter())
+		}
+		switch v := x.(type) {
+		case interface{ Unwrap() io.Reader }:
+			x = v.Unwrap()
+		case interface{ Unwrap() io.Writer }:
+			x = v.Unwrap()
+		case interface{ Unwrap() net.Conn }:
+			x = v.Unwrap()
+		case interface{ Unwrap() http.ResponseWriter }:
+			x = v.Unwrap()
+		default:
+			x = nil
+		}
+	}
+	return found
+}